@@ -1,16 +1,59 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"ihub/pkg/audit"
 	"ihub/pkg/config"
 	"ihub/pkg/db"
+	"ihub/pkg/health"
+	"ihub/pkg/logging"
+	"ihub/pkg/tracing"
 
 	"ihub/pkg/server"
 )
 
 func main() {
+	configFile := flag.String("config", "ihub-config.yaml", "配置文件路径")
+	confDir := flag.String("conf.d", "", "配置目录，其中的yaml文件按文件名顺序合并，默认为配置文件所在目录下的conf.d")
+	dump := flag.Bool("dump-config", false, "输出合并配置文件、配置目录及IHUB_环境变量后的生效配置及来源，然后退出")
+	verifyAudit := flag.String("verify-audit", "", "校验审计日志文件或导出的审计记录，输出校验结果后退出，校验失败时退出码为1")
+	flag.Parse()
+
+	if *verifyAudit != "" {
+		result, err := audit.VerifyFile(*verifyAudit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+		if !result.Valid {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	//todo use cache to instead of config and db
-	//init cofig from file or environment
-	if err := config.Init("ihub-config.yaml"); err != nil {
+	//init cofig from file, config directory and IHUB_ environment variables
+	if err := config.Init(config.Options{File: *configFile, ConfDir: *confDir, NoWatch: *dump}); err != nil {
+		panic(err)
+	}
+	if *dump {
+		fmt.Print(config.DumpSettings(config.GetConfig().Settings()))
+		os.Exit(0)
+	}
+
+	//init log file, format and rotation from the log config
+	if err := logging.Init(); err != nil {
+		panic(err)
+	}
+
+	//open the audit log and continue the hash chain from its last record
+	if err := audit.Init(); err != nil {
 		panic(err)
 	}
 
@@ -19,10 +62,18 @@ func main() {
 		panic(err)
 	}
 
+	//start health check of cluster gateways and modules
+	health.Start()
+
+	//start exporting spans to the OTLP collector
+	tracing.Start()
+
 	//init server
 	app := server.NewServer()
 	if app == nil {
 		panic("New Server failed.")
 	}
-	app.Run()
+	if err := app.Run(); err != nil {
+		panic(err)
+	}
 }
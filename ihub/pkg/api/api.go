@@ -5,4 +5,13 @@ type Reply struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Data    string `json:"data"`
+	Cluster string `json:"cluster,omitempty"`
+	Module  string `json:"module,omitempty"`
+}
+
+// DataReply 带结构化数据的返回，用于管理接口
+type DataReply struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
 }
@@ -0,0 +1,326 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tjfoc/gmsm/sm3"
+)
+
+// genesisHash 第一条记录的prevHash
+var genesisHash = strings.Repeat("0", 64)
+
+// Entry 一条审计记录，每行一条JSON。Hash为除Hash以外全部字段的SM3摘要，
+// 其中PrevHash为上一条记录的Hash，修改、插入或删除任意一条记录后，之后的记录都无法通过校验。
+type Entry struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// 请求者身份：UserID、GroupID为Auth校验令牌后的身份，管理接口为认证的管理员
+	TraceID  string `json:"traceId"`
+	UserID   string `json:"userId"`
+	GroupID  string `json:"groupId"`
+	ClientIP string `json:"clientIp"`
+	// ClaimedUserID、ClaimedGroupID 请求头X-User-ID、X-Group-ID中的身份，可以由客户端伪造，只作参考。
+	// 为空时不输出，之前写入的记录仍然是规范格式
+	ClaimedUserID  string `json:"claimedUserId,omitempty"`
+	ClaimedGroupID string `json:"claimedGroupId,omitempty"`
+	// 操作
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Cluster string `json:"cluster"`
+	Module  string `json:"module"`
+	// Operate OperatorTransMap中的操作名称
+	Operate string `json:"operate"`
+	// Approval 审批判断的结果(allowed、held、denied)，ApproveID、Approver为上游模块执行已审批请求后在响应头中返回的审批单及审批人，
+	// 不使用客户端请求头中的取值
+	Approval  string `json:"approval"`
+	ApproveID string `json:"approveId"`
+	Approver  string `json:"approver"`
+	// Status 返回给客户端的状态码，UpstreamError 上游不可达时的错误类型
+	Status        int    `json:"status"`
+	UpstreamError string `json:"upstreamError"`
+	PrevHash      string `json:"prevHash"`
+	Hash          string `json:"hash"`
+}
+
+// digest 计算记录的SM3摘要，摘要不包含Hash字段本身
+func (e Entry) digest() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	return hex.EncodeToString(sm3.Sm3Sum(b))
+}
+
+// store 只追加写入的审计日志文件
+type store struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	lastSeq  uint64
+	lastHash string
+}
+
+var audit store
+
+// Init 开启审计日志时打开审计日志文件，校验已有的记录并从最后一条记录继续。
+// 已有记录校验失败时只记录错误日志，之后的记录继续追加，校验命令可以定位被篡改的记录。
+func Init() error {
+	if !config.GetConfig().Audit.Enabled {
+		return nil
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	return audit.open()
+}
+
+// open 调用方需持有mu
+func (s *store) open() error {
+	if s.file != nil {
+		return nil
+	}
+	path := config.GetConfig().Audit.File
+	if path == "" {
+		path = constants.DefaultAuditFile
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	result, last, err := verify(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if !result.Valid {
+		logrus.WithFields(logrus.Fields{"file": path, "line": result.Line, "problem": result.Problem}).Error("audit log verification failed")
+	}
+	// 写入中断时最后一行不完整，补充换行符，之后的记录从新的一行开始，不完整的一行校验时报告
+	if err := terminate(file); err != nil {
+		file.Close()
+		return err
+	}
+	// 从文件中最后一条记录继续，校验失败时也不从中间分叉
+	s.path, s.file = path, file
+	s.lastSeq, s.lastHash = last.Seq, last.Hash
+	if s.lastHash == "" {
+		s.lastHash = genesisHash
+	}
+	return nil
+}
+
+// terminate 文件不以换行符结尾时追加换行符
+func terminate(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, info.Size()-1); err != nil {
+		return err
+	}
+	if b[0] != '\n' {
+		_, err = file.Write([]byte("\n"))
+	}
+	return err
+}
+
+// Enabled 是否开启了审计日志
+func Enabled() bool {
+	return config.GetConfig().Audit.Enabled
+}
+
+// Record 为记录分配序号并计算摘要后追加到审计日志，热加载开启审计日志时在第一次记录时打开文件
+func Record(e *Entry) error {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if err := audit.open(); err != nil {
+		metrics.AuditRecords.WithLabelValues("failed").Inc()
+		return err
+	}
+	e.Seq = audit.lastSeq + 1
+	e.Time = e.Time.UTC()
+	e.PrevHash = audit.lastHash
+	e.Hash = e.digest()
+	line, err := json.Marshal(e)
+	if err != nil {
+		metrics.AuditRecords.WithLabelValues("failed").Inc()
+		return err
+	}
+	// 一次写入整行，读取时不会读到半条记录之后的内容
+	if _, err := audit.file.Write(append(line, '\n')); err != nil {
+		metrics.AuditRecords.WithLabelValues("failed").Inc()
+		return err
+	}
+	if config.GetConfig().Audit.Sync {
+		if err := audit.file.Sync(); err != nil {
+			metrics.AuditRecords.WithLabelValues("failed").Inc()
+			return err
+		}
+	}
+	audit.lastSeq, audit.lastHash = e.Seq, e.Hash
+	metrics.AuditRecords.WithLabelValues("recorded").Inc()
+	return nil
+}
+
+// Path 返回正在写入的审计日志文件，没有开启审计日志时返回空字符串
+func Path() string {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	return audit.path
+}
+
+// Filter 导出的范围，导出连续的记录以便校验，为零的条件不限制
+type Filter struct {
+	FromSeq uint64
+	ToSeq   uint64
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.FromSeq == 0 || e.Seq >= f.FromSeq) &&
+		(f.ToSeq == 0 || e.Seq <= f.ToSeq) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Export 按原样输出范围内的记录，输出的内容可以直接使用校验命令校验
+func Export(w io.Writer, f Filter) error {
+	path := Path()
+	if path == "" {
+		return errors.New("audit log is not enabled")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return eachLine(file, func(line []byte, _ int) error {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil || !f.match(e) {
+			return nil
+		}
+		_, err := w.Write(append(line, '\n'))
+		return err
+	})
+}
+
+// Result 校验结果，Valid为false时Line为第一条有问题的记录所在的行
+type Result struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	FirstSeq uint64 `json:"firstSeq"`
+	LastSeq  uint64 `json:"lastSeq"`
+	LastHash string `json:"lastHash"`
+	Line     int    `json:"line,omitempty"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// Verify 校验审计记录：每条记录的摘要正确、prevHash等于上一条记录的摘要且序号连续。
+// 第一条记录的序号为1时prevHash必须为全0；导出的部分记录从第一条记录的prevHash开始校验。
+func Verify(r io.Reader) (Result, error) {
+	res, _, err := verify(r)
+	return res, err
+}
+
+// verify 同时返回最后一条可以解析的记录，审计日志从该记录继续
+func verify(r io.Reader) (Result, Entry, error) {
+	res := Result{Valid: true}
+	var last Entry
+	err := eachLine(r, func(line []byte, n int) error {
+		var e Entry
+		problem := ""
+		if err := json.Unmarshal(line, &e); err != nil {
+			problem = "invalid record: " + err.Error()
+		} else {
+			last = e
+			problem = check(e, line, res)
+		}
+		if !res.Valid {
+			return nil
+		}
+		if problem != "" {
+			res.Valid, res.Line, res.Problem = false, n, problem
+			return nil
+		}
+		if res.Entries == 0 {
+			res.FirstSeq = e.Seq
+		}
+		res.Entries++
+		res.LastSeq, res.LastHash = e.Seq, e.Hash
+		return nil
+	})
+	return res, last, err
+}
+
+// check 检查一条记录，res为之前记录的校验结果，没有问题时返回空字符串
+func check(e Entry, line []byte, res Result) string {
+	if canonical, _ := json.Marshal(e); !bytes.Equal(canonical, line) {
+		// 增加字段或改变格式同样视为篡改
+		return "record is not in canonical form"
+	}
+	if e.Hash != e.digest() {
+		return fmt.Sprintf("seq %d: hash mismatch", e.Seq)
+	}
+	if res.Entries == 0 {
+		if e.Seq == 1 && e.PrevHash != genesisHash {
+			return "seq 1: prevHash is not the genesis hash"
+		}
+		return ""
+	}
+	if e.Seq != res.LastSeq+1 {
+		return fmt.Sprintf("seq %d: expected seq %d", e.Seq, res.LastSeq+1)
+	}
+	if e.PrevHash != res.LastHash {
+		return fmt.Sprintf("seq %d: prevHash does not match the previous record", e.Seq)
+	}
+	return ""
+}
+
+// VerifyFile 校验审计日志文件，用于-verify-audit命令
+func VerifyFile(path string) (Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer file.Close()
+	return Verify(file)
+}
+
+// eachLine 依次处理每一行，n为行号。写入中没有换行符的最后一行不处理
+func eachLine(r io.Reader, fn func(line []byte, n int) error) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line, n); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chain 构造从seq 1开始的n条审计记录，每条记录一行，与Record写入的格式相同
+func chain(t *testing.T, n int) []string {
+	t.Helper()
+	var lines []string
+	prev := genesisHash
+	start := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		e := Entry{
+			Seq:      uint64(i),
+			Time:     start.Add(time.Duration(i) * time.Second),
+			UserID:   "1001",
+			Method:   "POST",
+			Path:     "/appstore/v1/store/create",
+			Module:   "appstore",
+			Status:   200,
+			PrevHash: prev,
+		}
+		e.Hash = e.digest()
+		lines = append(lines, marshal(t, e))
+		prev = e.Hash
+	}
+	return lines
+}
+
+func marshal(t *testing.T, e Entry) string {
+	t.Helper()
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func unmarshal(t *testing.T, line string) Entry {
+	t.Helper()
+	var e Entry
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestVerify(t *testing.T) {
+	lines := chain(t, 3)
+	join := func(lines ...string) string {
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	edited := unmarshal(t, lines[1])
+	edited.UserID = "1002"
+	// 修改字段后重新计算摘要，下一条记录的prevHash不再匹配
+	rehashed := edited
+	rehashed.Hash = rehashed.digest()
+	// 插入一条摘要正确的seq 2，原来的seq 2与其序号冲突
+	inserted := unmarshal(t, lines[1])
+	inserted.Path = "/appstore/v1/store/delete"
+	inserted.Hash = inserted.digest()
+	forked := unmarshal(t, lines[0])
+	forked.PrevHash = strings.Repeat("1", 64)
+	forked.Hash = forked.digest()
+
+	tests := []struct {
+		name  string
+		input string
+		want  Result
+		// problem Result.Problem的开头
+		problem string
+	}{
+		{"valid", join(lines...),
+			Result{Valid: true, Entries: 3, FirstSeq: 1, LastSeq: 3}, ""},
+		{"empty", "",
+			Result{Valid: true}, ""},
+		{"edited field", join(lines[0], marshal(t, edited), lines[2]),
+			Result{Entries: 1, FirstSeq: 1, LastSeq: 1, Line: 2}, "seq 2: hash mismatch"},
+		{"edited field and rehashed", join(lines[0], marshal(t, rehashed), lines[2]),
+			Result{Entries: 2, FirstSeq: 1, LastSeq: 2, Line: 3}, "seq 3: prevHash does not match the previous record"},
+		{"inserted line", join(lines[0], marshal(t, inserted), lines[1], lines[2]),
+			Result{Entries: 2, FirstSeq: 1, LastSeq: 2, Line: 3}, "seq 2: expected seq 3"},
+		{"deleted line", join(lines[0], lines[2]),
+			Result{Entries: 1, FirstSeq: 1, LastSeq: 1, Line: 2}, "seq 3: expected seq 2"},
+		// 写入中断后打开审计日志时补充换行符，不完整的一行作为记录校验
+		{"truncated last line", join(lines[0], lines[1], lines[2][:len(lines[2])/2]),
+			Result{Entries: 2, FirstSeq: 1, LastSeq: 2, Line: 3}, "invalid record"},
+		// 没有换行符的最后一行可能正在写入，不校验
+		{"unterminated last line", join(lines[0], lines[1]) + lines[2][:len(lines[2])/2],
+			Result{Valid: true, Entries: 2, FirstSeq: 1, LastSeq: 2}, ""},
+		{"non-canonical line", join(lines[0], strings.Replace(lines[1], `"seq":2,`, `"seq": 2,`, 1), lines[2]),
+			Result{Entries: 1, FirstSeq: 1, LastSeq: 1, Line: 2}, "record is not in canonical form"},
+		{"unknown field", join(lines[0], strings.Replace(lines[1], `{`, `{"note":"x",`, 1), lines[2]),
+			Result{Entries: 1, FirstSeq: 1, LastSeq: 1, Line: 2}, "record is not in canonical form"},
+		{"export from mid-chain", join(lines[1:]...),
+			Result{Valid: true, Entries: 2, FirstSeq: 2, LastSeq: 3}, ""},
+		{"seq 1 not from genesis", join(marshal(t, forked)),
+			Result{Line: 1}, "seq 1: prevHash is not the genesis hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got.Problem, tt.problem) || (tt.problem == "" && got.Problem != "") {
+				t.Errorf("problem = %q, want prefix %q", got.Problem, tt.problem)
+			}
+			got.Problem, got.LastHash = "", ""
+			if got != tt.want {
+				t.Errorf("Verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package balancer
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/db"
+	"ihub/pkg/health"
+)
+
+// 负载均衡策略
+const (
+	StrategyRoundRobin     = "round-robin"
+	StrategyLeastConn      = "least-conn"
+	StrategyConsistentHash = "consistent-hash"
+)
+
+// 一致性哈希的键
+const (
+	HashKeyUser  = "user"
+	HashKeyTrace = "trace"
+)
+
+// virtualNodes 一致性哈希中每个域名的虚拟节点数
+const virtualNodes = 100
+
+// ErrNoEndpoint 集群没有可用的域名
+var ErrNoEndpoint = errors.New("集群没有可用的域名")
+
+var (
+	mu       sync.Mutex
+	counters = map[string]*uint64{}
+	inflight = map[string]*int64{}
+	rings    = map[string]*hashRing{}
+)
+
+// Pick 从同一集群的多个域名中选择一个
+// 优先选择健康的主域名，主域名全部不健康时选择健康的备用域名，都不健康时在主域名中选择(由健康检查决定是否拒绝)。
+// hashValue为一致性哈希的键值(用户或trace id)，为空时退化为轮询。
+func Pick(endpoints []db.NameDomainId, module string, hashValue string) (db.NameDomainId, error) {
+	if len(endpoints) == 0 {
+		return db.NameDomainId{}, ErrNoEndpoint
+	}
+	if len(endpoints) == 1 {
+		return endpoints[0], nil
+	}
+	cfg := config.GetConfig().Balance
+	primaries, backups := split(cfg, endpoints)
+	candidates := healthy(primaries, module)
+	if len(candidates) == 0 {
+		candidates = healthy(backups, module)
+	}
+	if len(candidates) == 0 {
+		candidates = primaries
+	}
+	if len(candidates) == 0 {
+		candidates = backups
+	}
+
+	cluster := endpoints[0].Name
+	strategy, _ := Strategy(cluster)
+	switch {
+	case strategy == StrategyLeastConn:
+		return leastConn(cluster, candidates), nil
+	case strategy == StrategyConsistentHash && hashValue != "":
+		return consistentHash(cluster, candidates, hashValue), nil
+	default:
+		return roundRobin(cluster, candidates), nil
+	}
+}
+
+// Strategy 返回集群使用的负载均衡策略及一致性哈希的键
+func Strategy(cluster string) (string, string) {
+	cfg := config.GetConfig().Balance
+	strategy, hashKey := cfg.Strategy, cfg.HashKey
+	for _, c := range cfg.Clusters {
+		if c.Name == cluster {
+			if c.Strategy != "" {
+				strategy = c.Strategy
+			}
+			if c.HashKey != "" {
+				hashKey = c.HashKey
+			}
+		}
+	}
+	if hashKey == "" {
+		hashKey = HashKeyUser
+	}
+	return strategy, hashKey
+}
+
+// HashValue 根据集群配置的一致性哈希键，从请求头中获取用户或trace id
+func HashValue(header http.Header, cluster string) string {
+	if _, hashKey := Strategy(cluster); hashKey == HashKeyTrace {
+		return header.Get(constants.HTTPHeaderTraceID)
+	}
+	return header.Get(constants.HTTPHeaderUserID)
+}
+
+// Acquire 记录发往域名的请求数，用于最少连接策略，请求结束后调用返回的函数
+func Acquire(domain string) func() {
+	n := inflightCounter(domain)
+	atomic.AddInt64(n, 1)
+	return func() { atomic.AddInt64(n, -1) }
+}
+
+// split 按配置的备用记录ID拆分主域名和备用域名，结果按ID排序保证顺序稳定
+func split(cfg config.BalanceConfig, endpoints []db.NameDomainId) ([]db.NameDomainId, []db.NameDomainId) {
+	backupIds := map[int]bool{}
+	for _, id := range cfg.Backups {
+		backupIds[id] = true
+	}
+	var primaries, backups []db.NameDomainId
+	for _, ep := range endpoints {
+		if backupIds[ep.ID] {
+			backups = append(backups, ep)
+		} else {
+			primaries = append(primaries, ep)
+		}
+	}
+	byID := func(list []db.NameDomainId) {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	}
+	byID(primaries)
+	byID(backups)
+	return primaries, backups
+}
+
+func healthy(endpoints []db.NameDomainId, module string) []db.NameDomainId {
+	var list []db.NameDomainId
+	for _, ep := range endpoints {
+		if health.Check(ep.Domain, module) == nil {
+			list = append(list, ep)
+		}
+	}
+	return list
+}
+
+func roundRobin(cluster string, endpoints []db.NameDomainId) db.NameDomainId {
+	mu.Lock()
+	n, ok := counters[cluster]
+	if !ok {
+		n = new(uint64)
+		counters[cluster] = n
+	}
+	mu.Unlock()
+	i := atomic.AddUint64(n, 1) - 1
+	return endpoints[i%uint64(len(endpoints))]
+}
+
+func leastConn(cluster string, endpoints []db.NameDomainId) db.NameDomainId {
+	// 从轮询位置开始比较，连接数相同时依次选择不同的域名
+	start := roundRobin(cluster, endpoints)
+	offset := 0
+	for i, ep := range endpoints {
+		if ep.ID == start.ID {
+			offset = i
+		}
+	}
+	best := endpoints[offset]
+	bestN := atomic.LoadInt64(inflightCounter(best.Domain))
+	for i := 1; i < len(endpoints); i++ {
+		ep := endpoints[(offset+i)%len(endpoints)]
+		if n := atomic.LoadInt64(inflightCounter(ep.Domain)); n < bestN {
+			best, bestN = ep, n
+		}
+	}
+	return best
+}
+
+// hashRing 一致性哈希环，每个域名在环上有virtualNodes个虚拟节点
+type hashRing struct {
+	// key 生成哈希环的域名列表，候选域名变化(域名增减、健康状态或备用配置变化)时重新生成
+	key   string
+	nodes []ringNode
+}
+
+type ringNode struct {
+	hash uint32
+	ep   db.NameDomainId
+}
+
+// consistentHash 选择哈希环上顺时针方向第一个节点，哈希环按集群缓存，候选域名不变时不重新生成
+func consistentHash(cluster string, endpoints []db.NameDomainId, value string) db.NameDomainId {
+	nodes := ringOf(cluster, endpoints)
+	h := hashOf(value)
+	i := sort.Search(len(nodes), func(i int) bool { return nodes[i].hash >= h })
+	if i == len(nodes) {
+		i = 0
+	}
+	return nodes[i].ep
+}
+
+func ringOf(cluster string, endpoints []db.NameDomainId) []ringNode {
+	var b strings.Builder
+	for _, ep := range endpoints {
+		b.WriteString(strconv.Itoa(ep.ID))
+		b.WriteByte('=')
+		b.WriteString(ep.Domain)
+		b.WriteByte(',')
+	}
+	key := b.String()
+	mu.Lock()
+	defer mu.Unlock()
+	if r, ok := rings[cluster]; ok && r.key == key {
+		return r.nodes
+	}
+	nodes := make([]ringNode, 0, len(endpoints)*virtualNodes)
+	for _, ep := range endpoints {
+		for i := 0; i < virtualNodes; i++ {
+			nodes = append(nodes, ringNode{hashOf(ep.Domain + "#" + strconv.Itoa(i)), ep})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].hash < nodes[j].hash })
+	rings[cluster] = &hashRing{key: key, nodes: nodes}
+	return nodes
+}
+
+func hashOf(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func inflightCounter(domain string) *int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	n, ok := inflight[domain]
+	if !ok {
+		n = new(int64)
+		inflight[domain] = n
+	}
+	return n
+}
@@ -0,0 +1,251 @@
+package breaker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/metrics"
+)
+
+// State 熔断器状态
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// ErrOpen 熔断器打开时拒绝请求
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Breaker 单个(集群、模块)的熔断器
+// 关闭状态下统计窗口内的失败比例，达到阈值后打开；打开OpenDuration后进入半开状态，
+// 半开状态下只放行HalfOpenProbes个探测请求，全部成功后关闭，任意失败则重新打开。
+type Breaker struct {
+	mu       sync.Mutex
+	cluster  string
+	module   string
+	state    State
+	start    time.Time
+	requests int
+	failures int
+	openedAt time.Time
+	// 半开状态下正在进行及已成功的探测请求数
+	probing   int
+	succeeded int
+	// lastUsed 最近一次请求的时间，熔断器数达到上限时淘汰最久未使用的熔断器
+	lastUsed time.Time
+}
+
+// Status 熔断器状态快照，供管理接口使用
+type Status struct {
+	Cluster  string     `json:"cluster"`
+	Module   string     `json:"module"`
+	State    string     `json:"state"`
+	Requests int        `json:"requests"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+// Allow 判断是否放行请求，放行时返回的done需要在请求结束后调用，参数为请求是否成功
+func (b *Breaker) Allow(cfg config.BreakerConfig) (func(success bool), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.lastUsed = now
+	switch b.state {
+	case StateOpen:
+		if now.Sub(b.openedAt) < cfg.OpenDuration {
+			metrics.BreakerRejections.WithLabelValues(metrics.Cluster(b.cluster), metrics.Module(b.module)).Inc()
+			return nil, ErrOpen
+		}
+		b.setState(StateHalfOpen)
+		b.probing = 0
+		b.succeeded = 0
+		fallthrough
+	case StateHalfOpen:
+		if b.probing >= probes(cfg) {
+			metrics.BreakerRejections.WithLabelValues(metrics.Cluster(b.cluster), metrics.Module(b.module)).Inc()
+			return nil, ErrOpen
+		}
+		b.probing++
+		return func(success bool) { b.probeDone(cfg, success) }, nil
+	default:
+		if now.Sub(b.start) > cfg.Window {
+			b.start = now
+			b.requests = 0
+			b.failures = 0
+		}
+		return func(success bool) { b.done(cfg, success) }, nil
+	}
+}
+
+// done 关闭状态下记录请求结果，失败比例达到阈值时打开熔断器
+func (b *Breaker) done(cfg config.BreakerConfig, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateClosed {
+		return
+	}
+	b.requests++
+	if !success {
+		b.failures++
+	}
+	if b.requests >= cfg.MinRequests && float64(b.failures) >= float64(b.requests)*cfg.FailureRatio {
+		b.open()
+	}
+}
+
+// probeDone 半开状态下记录探测结果
+func (b *Breaker) probeDone(cfg config.BreakerConfig, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateHalfOpen {
+		return
+	}
+	if !success {
+		b.open()
+		return
+	}
+	b.succeeded++
+	if b.succeeded >= probes(cfg) {
+		b.setState(StateClosed)
+		b.start = time.Now()
+		b.requests = 0
+		b.failures = 0
+	}
+}
+
+func (b *Breaker) open() {
+	b.setState(StateOpen)
+	b.openedAt = time.Now()
+}
+
+func (b *Breaker) setState(state State) {
+	b.state = state
+	metrics.BreakerState.WithLabelValues(metrics.Cluster(b.cluster), metrics.Module(b.module)).Set(float64(state))
+}
+
+// State 返回熔断器当前状态
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := Status{
+		Cluster:  b.cluster,
+		Module:   b.module,
+		State:    b.state.String(),
+		Requests: b.requests,
+		Failures: b.failures,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		st.OpenedAt = &openedAt
+	}
+	return st
+}
+
+// probes 半开状态下的探测请求数，至少为1
+func probes(cfg config.BreakerConfig) int {
+	if cfg.HalfOpenProbes < 1 {
+		return 1
+	}
+	return cfg.HalfOpenProbes
+}
+
+type key struct {
+	cluster string
+	module  string
+}
+
+// maxBreakers 最多保存的熔断器数。模块名称来自请求路径，不限制时随机路径会使熔断器无限增加
+const maxBreakers = 10000
+
+var (
+	mu       sync.Mutex
+	breakers = map[key]*Breaker{}
+)
+
+// Get 返回(集群、模块)对应的熔断器，不存在时创建，熔断器数达到上限时先淘汰最久未使用的熔断器
+func Get(cluster string, module string) *Breaker {
+	mu.Lock()
+	defer mu.Unlock()
+	k := key{cluster, module}
+	b, ok := breakers[k]
+	if !ok {
+		if len(breakers) >= maxBreakers {
+			evict()
+		}
+		now := time.Now()
+		b = &Breaker{cluster: cluster, module: module, start: now, lastUsed: now}
+		breakers[k] = b
+	}
+	return b
+}
+
+// evict 淘汰最久未使用的关闭状态的熔断器，都不是关闭状态时淘汰最久未使用的熔断器，调用方需持有mu
+func evict() {
+	var oldest, oldestClosed *key
+	var oldestAt, oldestClosedAt time.Time
+	for k, b := range breakers {
+		k := k
+		b.mu.Lock()
+		used, closed := b.lastUsed, b.state == StateClosed
+		b.mu.Unlock()
+		if oldest == nil || used.Before(oldestAt) {
+			oldest, oldestAt = &k, used
+		}
+		if closed && (oldestClosed == nil || used.Before(oldestClosedAt)) {
+			oldestClosed, oldestClosedAt = &k, used
+		}
+	}
+	if oldestClosed != nil {
+		oldest = oldestClosed
+	}
+	if oldest != nil {
+		delete(breakers, *oldest)
+	}
+}
+
+// Snapshot 返回所有熔断器的状态，按集群、模块排序
+func Snapshot() []Status {
+	mu.Lock()
+	list := make([]*Breaker, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, b)
+	}
+	mu.Unlock()
+
+	statuses := make([]Status, 0, len(list))
+	for _, b := range list {
+		statuses = append(statuses, b.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Cluster != statuses[j].Cluster {
+			return statuses[i].Cluster < statuses[j].Cluster
+		}
+		return statuses[i].Module < statuses[j].Module
+	})
+	return statuses
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry 缓存的响应
+type Entry struct {
+	// Group 为集群及模块，模块有增删改请求成功后整组失效
+	Group  string
+	Status int
+	Header http.Header
+	Body   []byte
+	ETag   string
+	// Stored 写入缓存的时间，Expires 过期时间
+	Stored  time.Time
+	Expires time.Time
+}
+
+// Fresh 判断缓存是否仍在有效期内
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// Key 由集群、模块、路径、查询参数、身份范围及Accept-Encoding组成缓存键，
+// 不区分其他请求头，Vary中有其他请求头的响应由Cache中间件拒绝缓存
+func Key(cluster string, module string, path string, query string, scope string, encoding string) string {
+	return strings.Join([]string{Group(cluster, module), path, query, scope, encoding}, "\x00")
+}
+
+// Group 返回集群及模块对应的失效分组
+func Group(cluster string, module string) string {
+	return cluster + "\x00" + module
+}
+
+type item struct {
+	key   string
+	entry *Entry
+}
+
+var (
+	mu    sync.Mutex
+	lru   = list.New()
+	items = map[string]*list.Element{}
+	// generations 每个分组的失效次数，请求开始前后的次数不同时不写入缓存，避免写入失效前的响应
+	generations = map[string]uint64{}
+)
+
+// Generation 返回分组当前的失效次数
+func Generation(group string) uint64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return generations[group]
+}
+
+// Get 先从内存中查找，找不到时从磁盘加载。超过MaxStale的过期响应会被删除。
+func Get(group string, key string) (*Entry, bool) {
+	cfg := config.GetConfig().CACHE
+	now := time.Now()
+	mu.Lock()
+	if el, ok := items[key]; ok {
+		e := el.Value.(*item).entry
+		if expired(cfg, e, now) {
+			remove(el)
+			mu.Unlock()
+			removeFile(cfg, key, e.Group)
+			return nil, false
+		}
+		lru.MoveToFront(el)
+		mu.Unlock()
+		return e, true
+	}
+	mu.Unlock()
+
+	e, ok := readFile(cfg, key, group)
+	if !ok {
+		return nil, false
+	}
+	if expired(cfg, e, now) {
+		removeFile(cfg, key, e.Group)
+		return nil, false
+	}
+	mu.Lock()
+	add(cfg, key, e)
+	mu.Unlock()
+	return e, true
+}
+
+// Set 写入缓存，分组在generation之后失效过时忽略
+func Set(key string, e *Entry, generation uint64) {
+	cfg := config.GetConfig().CACHE
+	mu.Lock()
+	if generations[e.Group] != generation {
+		mu.Unlock()
+		return
+	}
+	add(cfg, key, e)
+	mu.Unlock()
+	writeFile(cfg, key, e)
+	// 写文件期间分组失效时删除刚写入的文件
+	if Generation(e.Group) != generation {
+		removeFile(cfg, key, e.Group)
+	}
+}
+
+// Invalidate 删除集群及模块下的所有缓存
+func Invalidate(cluster string, module string) {
+	group := Group(cluster, module)
+	mu.Lock()
+	generations[group]++
+	for el := lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*item).entry.Group == group {
+			remove(el)
+		}
+		el = next
+	}
+	mu.Unlock()
+	if dir := groupDir(config.GetConfig().CACHE, group); dir != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.WithFields(logrus.Fields{"dir": dir, "error": err}).Warn("remove cache failed")
+		}
+	}
+}
+
+// MaxEntryBytes 返回可缓存的最大响应体
+func MaxEntryBytes() int64 {
+	if n := config.GetConfig().CACHE.MaxEntryBytes; n > 0 {
+		return n
+	}
+	return constants.DefaultCacheEntryBytes
+}
+
+// expired 判断是否需要删除缓存，带ETag的响应在过期后MaxStale内保留用于条件请求
+func expired(cfg config.CacheConfig, e *Entry, now time.Time) bool {
+	if e.Fresh(now) {
+		return false
+	}
+	return e.ETag == "" || now.After(e.Expires.Add(cfg.MaxStale))
+}
+
+func add(cfg config.CacheConfig, key string, e *Entry) {
+	if el, ok := items[key]; ok {
+		el.Value.(*item).entry = e
+		lru.MoveToFront(el)
+		return
+	}
+	items[key] = lru.PushFront(&item{key: key, entry: e})
+	max := cfg.MaxEntries
+	if max <= 0 {
+		max = constants.DefaultCacheEntries
+	}
+	// 内存中淘汰的响应仍保留在磁盘上
+	for lru.Len() > max {
+		remove(lru.Back())
+	}
+}
+
+func remove(el *list.Element) {
+	lru.Remove(el)
+	delete(items, el.Value.(*item).key)
+}
+
+// groupDir 磁盘上按分组存放缓存文件，分组失效时删除整个目录
+func groupDir(cfg config.CacheConfig, group string) string {
+	if cfg.CachePath == "" {
+		return ""
+	}
+	return filepath.Join(cfg.CachePath, hash(group))
+}
+
+func filePath(cfg config.CacheConfig, key string, group string) string {
+	dir := groupDir(cfg, group)
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, hash(key))
+}
+
+func readFile(cfg config.CacheConfig, key string, group string) (*Entry, bool) {
+	path := filePath(cfg, key, group)
+	if path == "" {
+		return nil, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var e Entry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+// writeFile 先写临时文件再重命名，避免读到写了一半的文件
+func writeFile(cfg config.CacheConfig, key string, e *Entry) {
+	path := filePath(cfg, key, e.Group)
+	if path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logrus.WithFields(logrus.Fields{"path": path, "error": err}).Warn("write cache failed")
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"path": path, "error": err}).Warn("write cache failed")
+		return
+	}
+	err = gob.NewEncoder(f).Encode(e)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		logrus.WithFields(logrus.Fields{"path": path, "error": err}).Warn("write cache failed")
+	}
+}
+
+func removeFile(cfg config.CacheConfig, key string, group string) {
+	if path := filePath(cfg, key, group); path != "" {
+		os.Remove(path)
+	}
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,10 +1,8 @@
 package config
 
 import (
-	"fmt"
-
-	"github.com/fsnotify/fsnotify"
-	"github.com/spf13/viper"
+	"strings"
+	"time"
 )
 
 // DBConfig .
@@ -13,7 +11,7 @@ type DBConfig struct {
 	Host           string `yaml:"HOST"`
 	Port           int    `yaml:"PORT"`
 	User           string `yaml:"USER"`
-	Passwd         string `yaml:"PASSWD"`
+	Passwd         string `yaml:"PASSWD" secret:"true"`
 	Charset        string `yaml:"CHARSET"`
 	SM2PrivateFile string `yaml:"SM2PRIVATEFILE"`
 	MaxOpenConns   int    `yaml:"MAXOPENCONNS"`
@@ -22,22 +20,419 @@ type DBConfig struct {
 
 // LogConfig ...
 type LogConfig struct {
-	Level             string `yaml:"level"`
+	Level string `yaml:"level"`
+	// DefaultConfigName 日志文件路径，默认为工作目录下的ihub.log，目录不存在时创建
 	DefaultConfigName string `yaml:"defaultConfigName"`
+	// Format 日志格式：text(默认)或json，访问日志及网关自身的日志都使用该格式
+	Format string `yaml:"format"`
+	// Rotate 日志文件的轮转及保留
+	Rotate LogRotateConfig `yaml:"rotate"`
+	// Modules 按模块设置访问日志的级别，覆盖level及log中间件的level，如cluster-manager: "DEBUG"
+	Modules map[string]string `yaml:"modules"`
+	// MaxCaptureBytes 日志中记录请求/响应体的最大字节数，超出部分截断
+	MaxCaptureBytes int `yaml:"maxCaptureBytes"`
+	// SkipContentTypes 不记录请求/响应体的Content-Type前缀，二进制及multipart默认不记录
+	SkipContentTypes []string `yaml:"skipContentTypes"`
+	// RedactHeaders 记录时隐藏取值的请求/响应头，Authorization、Cookie等默认隐藏
+	RedactHeaders []string `yaml:"redactHeaders"`
+	// RedactFields 记录JSON请求/响应体时隐藏取值的字段，password、token等默认隐藏
+	RedactFields []string `yaml:"redactFields"`
+	// Sampling 访问日志采样规则，按顺序使用第一条匹配的规则，没有匹配的规则时全部记录
+	Sampling []LogSamplingRule `yaml:"sampling"`
+	// SlowThreshold 耗时超过该时间的请求按WARN记录，包含数据库、上游及中间件的耗时，不受采样影响。
+	// 为0时不记录，可以在路由中覆盖
+	SlowThreshold time.Duration `yaml:"slowThreshold"`
+}
+
+// LogSamplingRule 访问日志采样规则，条件为空时匹配全部请求，如成功的GET请求记录1%：
+// methods: ["GET"], statuses: ["2xx"], rate: 0.01
+type LogSamplingRule struct {
+	Modules []string `yaml:"modules"`
+	Methods []string `yaml:"methods"`
+	// Statuses 状态码或状态码类别，如404、5xx
+	Statuses []string `yaml:"statuses"`
+	// Rate 记录的比例，0到1，为0时不记录
+	Rate float64 `yaml:"rate"`
+}
+
+// LogRotateConfig 日志文件轮转配置，MaxSize及Interval都为0时不轮转
+type LogRotateConfig struct {
+	// MaxSize 日志文件超过该字节数后轮转
+	MaxSize int64 `yaml:"maxSize"`
+	// Interval 日志文件打开超过该时间后轮转，如24h
+	Interval time.Duration `yaml:"interval"`
+	// MaxBackups 最多保留的轮转文件数，MaxAge 轮转文件的最长保留时间，为0时不限制
+	MaxBackups int           `yaml:"maxBackups"`
+	MaxAge     time.Duration `yaml:"maxAge"`
 }
 
 // ServerConfig ...
 type ServerConfig struct {
 	Port int `yaml:"port"`
+	// MaxBodyBytes 请求体的最大字节数，0为不限制，可以在路由中覆盖
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
 }
 
-// CacheConfig ...
+// CacheConfig 响应缓存配置，只对配置了cache的路由生效
 type CacheConfig struct {
+	// CachePath 响应缓存的磁盘目录，为空时只缓存在内存中
 	CachePath string `yaml:"cachePath"`
+	// MaxEntries 内存中最多缓存的响应数，超过后淘汰最久未使用的响应
+	MaxEntries int `yaml:"maxEntries"`
+	// MaxEntryBytes 单个响应体的最大字节数，超过时不缓存
+	MaxEntryBytes int64 `yaml:"maxEntryBytes"`
+	// MaxStale 带ETag的响应过期后继续保留的时间，期间向上游发送条件请求(If-None-Match)
+	MaxStale time.Duration `yaml:"maxStale"`
+}
+
+// RouteCacheConfig 路由的响应缓存配置
+type RouteCacheConfig struct {
+	// TTL 上游没有返回Cache-Control max-age时的缓存时间
+	TTL time.Duration `yaml:"ttl"`
+	// Scope 缓存的身份范围：为空时所有用户共享，user、group按Auth校验令牌后的用户、组分别缓存，
+	// Auth实现令牌校验之前只能为空
+	Scope string `yaml:"scope"`
+}
+
+// RouteConfig 单个路由的配置，按模块名称及路径前缀匹配
+type RouteConfig struct {
+	Module string `yaml:"module"`
+	// Path 为模块内的路径前缀，为空时匹配整个模块
+	Path string `yaml:"path"`
+	// Stream 为true时按长连接处理(WebSocket、SSE、日志跟踪)，不缓存请求和响应体
+	Stream bool `yaml:"stream"`
+	// Service 为gRPC服务全名(package.Service)，gRPC及gRPC-Web请求按服务名路由到Module
+	Service string `yaml:"service"`
+	// Operates 为gRPC方法名到操作名称(operatorTransMap)的映射，审批时使用操作名称，方法名不区分大小写
+	Operates map[string]string `yaml:"operates"`
+	// MaxBodyBytes 不为0时覆盖server.maxBodyBytes
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+	// RateLimits 只对该路由生效的限流规则
+	RateLimits []RateLimitRule `yaml:"rateLimits"`
+	// Retry 不为空时覆盖proxy.retry中的重试配置
+	Retry *RetryConfig `yaml:"retry"`
+	// Concurrency 不为空时覆盖proxy.concurrency中的并发限制
+	Concurrency *ConcurrencyConfig `yaml:"concurrency"`
+	// Cache 不为空时缓存该路由的GET响应
+	Cache *RouteCacheConfig `yaml:"cache"`
+	// Mirror 不为空时将部分请求复制到镜像集群
+	Mirror *MirrorConfig `yaml:"mirror"`
+	// Rewrite 转发前后对请求及响应的改写规则
+	Rewrite *RewriteConfig `yaml:"rewrite"`
+	// CSP 不为空时覆盖security.csp
+	CSP string `yaml:"csp"`
+	// CORS 不为空时覆盖cors中的跨域配置
+	CORS *CORSConfig `yaml:"cors"`
+	// SlowThreshold 不为0时覆盖log.slowThreshold
+	SlowThreshold time.Duration `yaml:"slowThreshold"`
+}
+
+// RetryConfig 代理重试配置
+type RetryConfig struct {
+	// Attempts 最大尝试次数(包括第一次请求)，小于等于1时不重试
+	Attempts int `yaml:"attempts"`
+	// Backoff 第一次重试前的等待时间，之后按指数增长并加入随机抖动，不超过MaxBackoff
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// Statuses 上游返回这些状态码时重试，如502、503、504
+	Statuses []int `yaml:"statuses"`
+	// MaxBodyBytes 为重试缓存的请求体上限，超过该大小的请求不重试
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+	// BudgetRatio 每个集群重试次数占请求数的最大比例，MinRetries为每个统计周期内总是允许的重试次数
+	BudgetRatio float64 `yaml:"budgetRatio"`
+	MinRetries  int     `yaml:"minRetries"`
+}
+
+// BreakerConfig 熔断配置，按(集群、模块)统计
+type BreakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Window 统计周期，周期内请求数达到MinRequests且失败比例达到FailureRatio时熔断
+	Window       time.Duration `yaml:"window"`
+	MinRequests  int           `yaml:"minRequests"`
+	FailureRatio float64       `yaml:"failureRatio"`
+	// OpenDuration 熔断持续时间，之后进入半开状态，放行HalfOpenProbes个探测请求
+	OpenDuration   time.Duration `yaml:"openDuration"`
+	HalfOpenProbes int           `yaml:"halfOpenProbes"`
+}
+
+// RewriteConfig 路由的改写规则
+type RewriteConfig struct {
+	// Path 按正则表达式改写转发到上游的路径
+	Path *PathRewriteConfig `yaml:"path"`
+	// Query 添加到转发请求中的查询参数，已存在时覆盖
+	Query []NameValue `yaml:"query"`
+	// RequestHeaders 转发到上游前改写请求头，ResponseHeaders 返回客户端前改写响应头
+	RequestHeaders  HeaderRewriteConfig `yaml:"requestHeaders"`
+	ResponseHeaders HeaderRewriteConfig `yaml:"responseHeaders"`
+}
+
+// PathRewriteConfig Pattern匹配转发路径(不包括模块名称)，Replacement中可以使用$1等分组
+type PathRewriteConfig struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// HeaderRewriteConfig 请求头改写规则，按Remove、Rename、Set、Add的顺序执行
+type HeaderRewriteConfig struct {
+	Remove []string       `yaml:"remove"`
+	Rename []HeaderRename `yaml:"rename"`
+	Set    []NameValue    `yaml:"set"`
+	Add    []NameValue    `yaml:"add"`
+}
+
+// HeaderRename .
+type HeaderRename struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// NameValue 名称及取值，配置文件中map的key会被转换为小写，请求头及查询参数使用列表配置
+type NameValue struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// MirrorConfig 流量镜像配置，镜像请求异步发送，响应直接丢弃
+type MirrorConfig struct {
+	// Cluster 镜像集群名称，按cluster_manager中的域名转发；Target 为固定地址，优先于Cluster
+	Cluster string `yaml:"cluster"`
+	Target  string `yaml:"target"`
+	// Percent 镜像的请求比例，0-100
+	Percent float64 `yaml:"percent"`
+	// Methods 镜像的请求方法，默认只镜像GET、HEAD
+	Methods []string `yaml:"methods"`
+	// Timeout 镜像请求的超时时间
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxBodyBytes 镜像时缓存的请求体上限，超过该大小的请求不镜像
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+	// Compare 为true时记录镜像请求与原请求的状态码及耗时差异
+	Compare bool `yaml:"compare"`
+}
+
+// ConcurrencyConfig 并发限制配置，按(集群、模块)统计正在处理的请求数
+type ConcurrencyConfig struct {
+	// MaxInflight 同时发往上游的最大请求数，为0时不限制
+	MaxInflight int `yaml:"maxInflight"`
+	// QueueSize 超过MaxInflight后最多排队的请求数，排队超过QueueTimeout后返回503
+	QueueSize    int           `yaml:"queueSize"`
+	QueueTimeout time.Duration `yaml:"queueTimeout"`
+	// Adaptive 为true时根据响应时间(梯度算法)在[MinInflight, MaxInflight]之间调整并发上限
+	Adaptive    bool `yaml:"adaptive"`
+	MinInflight int  `yaml:"minInflight"`
+}
+
+// SheddingConfig ihub过载保护配置
+type SheddingConfig struct {
+	// MaxInflight ihub整体正在处理的请求数超过该值时拒绝所有请求，为0时不限制
+	MaxInflight int `yaml:"maxInflight"`
+	// LowPriorityInflight 超过该值时拒绝低优先级请求(GET、HEAD等查询)，保留余量给增删改请求，为0时不区分优先级
+	LowPriorityInflight int `yaml:"lowPriorityInflight"`
+}
+
+// HealthConfig 集群网关及模块的主动健康检查配置
+type HealthConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// 集群网关的检查地址为http://<ClusterModule>.default.<域名><ClusterPath>
+	ClusterModule string `yaml:"clusterModule"`
+	ClusterPath   string `yaml:"clusterPath"`
+	// Modules 需要单独检查的模块及其健康检查路径
+	Modules []ModuleHealthConfig `yaml:"modules"`
+	// 连续失败UnhealthyThreshold次后标记为不健康，连续成功HealthyThreshold次后恢复
+	UnhealthyThreshold int `yaml:"unhealthyThreshold"`
+	HealthyThreshold   int `yaml:"healthyThreshold"`
+	// Reject 为true时InOut直接拒绝访问不健康集群的请求
+	Reject bool `yaml:"reject"`
+}
+
+// ModuleHealthConfig .
+type ModuleHealthConfig struct {
+	Module string `yaml:"module"`
+	Path   string `yaml:"path"`
+}
+
+// BalanceConfig 同一集群名称注册多个域名时的负载均衡配置
+type BalanceConfig struct {
+	// Strategy 负载均衡策略：round-robin(默认)、least-conn、consistent-hash
+	Strategy string `yaml:"strategy"`
+	// HashKey 一致性哈希的键：user(默认，X-User-ID)、trace(X-Trace-ID)
+	HashKey string `yaml:"hashKey"`
+	// Backups 作为备用域名的cluster_manager记录ID，主域名全部不健康时才使用
+	Backups []int `yaml:"backups"`
+	// Clusters 按集群名称覆盖策略
+	Clusters []ClusterBalanceConfig `yaml:"clusters"`
+}
+
+// ClusterBalanceConfig .
+type ClusterBalanceConfig struct {
+	Name     string `yaml:"name"`
+	Strategy string `yaml:"strategy"`
+	HashKey  string `yaml:"hashKey"`
+}
+
+// RateLimitConfig 限流配置
+type RateLimitConfig struct {
+	// Backend 令牌桶存储：memory(默认，进程内)、redis(多实例共享)
+	Backend string      `yaml:"backend"`
+	Redis   RedisConfig `yaml:"redis"`
+	// FailOpen 为true时限流后端出错放行请求，否则返回错误
+	FailOpen bool `yaml:"failOpen"`
+	// Rules 对所有请求生效的限流规则，路由中的rateLimits只对该路由生效
+	Rules []RateLimitRule `yaml:"rules"`
+}
+
+// RateLimitRule 令牌桶限流规则
+type RateLimitRule struct {
+	// Key 限流维度：user、group、ip、cluster、module。user、group使用Auth校验后的身份，没有时按客户端IP限流；
+	// cluster、module在请求中没有该维度的值时规则不生效
+	Key string `yaml:"key"`
+	// Rate 每秒生成的令牌数，Burst 令牌桶容量
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// RedisConfig .
+type RedisConfig struct {
+	Addr      string        `yaml:"addr"`
+	Password  string        `yaml:"password" secret:"true"`
+	DB        int           `yaml:"db"`
+	Timeout   time.Duration `yaml:"timeout"`
+	KeyPrefix string        `yaml:"keyPrefix"`
+}
+
+// CanaryConfig 模块的灰度路由配置，请求按权重转发到模块的不同版本
+type CanaryConfig struct {
+	Module string `yaml:"module"`
+	// Versions 各版本的模块名称及权重，如appstore 90、appstore-v2 10
+	Versions []CanaryVersion `yaml:"versions"`
+	// Header 请求头的值为某个版本的模块名称时直接使用该版本，用于测试人员指定版本
+	Header string `yaml:"header"`
+	// Cookie 同Header，Sticky为cookie时分配的版本也写入该Cookie
+	Cookie string `yaml:"cookie"`
+	// Users 指定用户使用的版本，用户ID不区分大小写。只使用校验过的身份，Auth实现令牌校验之前不能配置
+	Users map[string]string `yaml:"users"`
+	// Sticky 粘性分配：user按校验过的用户ID哈希(Auth实现令牌校验之前不能使用)，cookie将分配结果写入Cookie，
+	// 为空时每个请求单独分配
+	Sticky string `yaml:"sticky"`
+}
+
+// CanaryVersion .
+type CanaryVersion struct {
+	Module string `yaml:"module"`
+	Weight int    `yaml:"weight"`
+}
+
+// ResponseHeaderConfig 上游响应头的过滤配置，Server及X-Powered-By总是删除
+type ResponseHeaderConfig struct {
+	// Deny 删除的响应头，以*结尾时按前缀匹配，如X-Debug-*
+	Deny []string `yaml:"deny"`
+	// Allow 不为空时只保留这些响应头及Content-Type等必需的响应头，同样支持*结尾的前缀匹配
+	Allow []string `yaml:"allow"`
+}
+
+// SecurityConfig 添加到上游响应中的安全响应头，为空时不添加，上游已经返回时不覆盖
+type SecurityConfig struct {
+	// HSTS Strict-Transport-Security，如max-age=31536000; includeSubDomains
+	HSTS string `yaml:"hsts"`
+	// ContentTypeOptions X-Content-Type-Options，一般为nosniff
+	ContentTypeOptions string `yaml:"contentTypeOptions"`
+	// FrameOptions X-Frame-Options，如DENY、SAMEORIGIN
+	FrameOptions string `yaml:"frameOptions"`
+	// ReferrerPolicy Referrer-Policy
+	ReferrerPolicy string `yaml:"referrerPolicy"`
+	// CSP Content-Security-Policy，可以在路由中覆盖
+	CSP string `yaml:"csp"`
+}
+
+// CORSConfig 网关统一处理跨域请求，上游返回的Access-Control-*响应头会被删除
+type CORSConfig struct {
+	// AllowOrigins 允许的来源，*表示所有来源，*.example.com匹配子域名
+	AllowOrigins []string `yaml:"allowOrigins"`
+	// AllowMethods 为空时允许GET、POST、PUT、PATCH、DELETE
+	AllowMethods []string `yaml:"allowMethods"`
+	// AllowHeaders 为空时允许预检请求中的所有请求头
+	AllowHeaders     []string      `yaml:"allowHeaders"`
+	ExposeHeaders    []string      `yaml:"exposeHeaders"`
+	AllowCredentials bool          `yaml:"allowCredentials"`
+	MaxAge           time.Duration `yaml:"maxAge"`
+}
+
+// ProxyConfig 代理配置
+type ProxyConfig struct {
+	Retry       RetryConfig       `yaml:"retry"`
+	Breaker     BreakerConfig     `yaml:"breaker"`
+	Concurrency ConcurrencyConfig `yaml:"concurrency"`
+	Shedding    SheddingConfig    `yaml:"shedding"`
+	// StripHeaders 转发到上游前删除的内部请求头，如X-Cluster-Name
+	StripHeaders []string `yaml:"stripHeaders"`
+	// TrustForwarded 为true时保留客户端传入的X-Forwarded-*及Forwarded并追加本次转发的信息，为false时覆盖
+	TrustForwarded bool `yaml:"trustForwarded"`
+	// ResponseHeaders 上游响应头的过滤配置
+	ResponseHeaders ResponseHeaderConfig `yaml:"responseHeaders"`
 }
 
 type MidwareConfig struct {
 	Midware string `yaml:"midware"`
+	// Modules 只对这些模块生效，为空时对所有模块生效
+	Modules []string `yaml:"modules"`
+	// SkipPaths 不经过该中间件的路径前缀(模块名称之后的路径)，如/v1/healthz
+	SkipPaths []string `yaml:"skipPaths"`
+	// Config 中间件自己的配置项，由中间件解析，如log中间件的level
+	Config map[string]interface{} `yaml:"config"`
+}
+
+// MetricsConfig /metrics监控指标配置，限制标签的取值个数
+type MetricsConfig struct {
+	// Clusters 集群标签的白名单，其他集群记为other；为空时记录最先出现的MaxClusters个集群
+	Clusters    []string `yaml:"clusters"`
+	MaxClusters int      `yaml:"maxClusters"`
+	// MaxModules 配置文件中没有出现的模块最多记录的个数
+	MaxModules int `yaml:"maxModules"`
+	// MaxEndpoints 每个模块最多记录的接口数，EndpointDepth 接口标签保留的路径层数
+	MaxEndpoints  int `yaml:"maxEndpoints"`
+	EndpointDepth int `yaml:"endpointDepth"`
+}
+
+// TracingConfig 分布式追踪配置，按W3C traceparent传递追踪上下文，span通过OTLP/HTTP导出到collector。
+// 需要在midwares中配置trace中间件。
+type TracingConfig struct {
+	// Endpoint collector的OTLP/HTTP地址，如http://otel-collector:4318，为空时只传递追踪上下文，不导出span
+	Endpoint string `yaml:"endpoint"`
+	// Headers 导出时附加的请求头，如认证信息
+	Headers map[string]string `yaml:"headers" secret:"true"`
+	// ServiceName 导出的service.name，默认为ihub
+	ServiceName string `yaml:"serviceName"`
+	// Sampler 采样策略，与OTEL_TRACES_SAMPLER的取值相同：parentbased_always_on(默认)、parentbased_always_off、
+	// parentbased_traceidratio、always_on、always_off、traceidratio。parentbased_*在请求带有traceparent时按上游的采样标记
+	Sampler string `yaml:"sampler"`
+	// SampleRatio traceidratio及parentbased_traceidratio的采样比例
+	SampleRatio float64 `yaml:"sampleRatio"`
+	// BatchSize 每次导出的最大span数，FlushInterval 导出间隔，Timeout 导出超时
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	Timeout       time.Duration `yaml:"timeout"`
+	// QueueSize 等待导出的最大span数，超出时丢弃，修改后需要重启
+	QueueSize int `yaml:"queueSize"`
+}
+
+// AuditConfig 审计日志配置，记录经过网关的变更请求(GET、HEAD、OPTIONS以外的请求)及管理接口的操作。
+// 每条记录包含上一条记录的SM3摘要，修改或删除任意一条记录都可以通过校验发现。
+type AuditConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// File 审计日志文件，只追加写入，默认为工作目录下的audit.log，修改后需要重启
+	File string `yaml:"file"`
+	// Sync 为true时每条记录写入后同步到磁盘，断电时不丢失记录
+	Sync bool `yaml:"sync"`
+}
+
+// AdminConfig 管理接口配置。管理接口只在Addr上提供，不经过代理端口，默认只监听本机
+type AdminConfig struct {
+	// Addr 管理接口的监听地址，默认为127.0.0.1:30419，修改后需要重启
+	Addr string `yaml:"addr"`
+	// Tokens 管理员名称到访问令牌的映射，不为空时请求需要携带Authorization: Bearer <令牌>。
+	// Addr不是本机地址时必须配置
+	Tokens map[string]string `yaml:"tokens" secret:"true"`
 }
 
 // Configuration ...
@@ -46,9 +441,26 @@ type Configuration struct {
 	LOG        LogConfig       `yaml:"log"`
 	SERVER     ServerConfig    `yaml:"server"`
 	CACHE      CacheConfig     `yaml:"cache"`
+	Proxy      ProxyConfig     `yaml:"proxy"`
+	Health     HealthConfig    `yaml:"health"`
+	Balance    BalanceConfig   `yaml:"balance"`
+	RateLimit  RateLimitConfig `yaml:"rateLimit"`
+	Security   SecurityConfig  `yaml:"security"`
+	CORS       CORSConfig      `yaml:"cors"`
+	Routes     []RouteConfig   `yaml:"routes"`
+	Canaries   []CanaryConfig  `yaml:"canaries"`
 	Midwares   []MidwareConfig `yaml:"midwares"`
 	Runmode    string          `yaml:"runmode"`
 	ApproveMap ApproveConfig   `yaml:"approveMap"`
+	Metrics    MetricsConfig   `yaml:"metrics"`
+	Tracing    TracingConfig   `yaml:"tracing"`
+	Audit      AuditConfig     `yaml:"audit"`
+	Admin      AdminConfig     `yaml:"admin"`
+	// Kubernetes 从ConfigMap或ApprovalRule读取审批映射
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+
+	// settings 生效的每一项配置及其来源，用于输出生效配置
+	settings []Setting
 }
 
 type ApproveConfig struct {
@@ -60,51 +472,69 @@ type ApproveConfig struct {
 	OuterServicePortMap   map[string]string   `yaml:"outerServicePortMap"`
 }
 
-var gConfig Configuration
-
-var configInstance *viper.Viper
-
-// 初始化
-// 配置文件路径
-func Init(filename string) error {
-	// 设置配置文件的名字
-	viper.SetConfigFile(filename)
-	// 设置配置文件的类型
-	// viper.SetConfigType("yaml")
-	// 添加配置文件所在的路径
-	// viper.AddConfigPath("../hfproxy-config.yaml")
-	// 启动自动检索配置文件中的环境变量并导入viper功能
-	viper.AutomaticEnv()
-	// 读取并载入配置文件至内存
-	err := viper.ReadInConfig()
+// Init 读取配置文件、配置目录、Kubernetes中的审批映射及环境变量，检查通过后作为第一个版本生效，
+// 之后监听配置文件及配置目录的变化并热加载，opts.NoWatch为true时不监听
+func Init(opts Options) error {
+	cfg, err := load(opts)
 	if err != nil {
 		return err
 	}
+	// 审批映射可以来自Kubernetes，读取后重新合并
+	if cfg.Kubernetes.Enabled {
+		if err := startKubernetes(opts, cfg.Kubernetes); err != nil {
+			return err
+		}
+		if cfg, err = load(opts); err != nil {
+			return err
+		}
+		kubeReady.Store(true)
+	}
+	current.Store(cfg)
+	version.Store(1)
 
-	// 检查配置文件是否合法
-	if err := validateConfig(viper.GetViper()); err != nil {
-		return err
+	if opts.NoWatch {
+		return nil
 	}
+	// 监听配置文件的变化，检查通过后整体替换当前配置，检查失败时继续使用原配置
+	return watch(opts)
+}
 
-	// 将配置文件的数据反序列化到结构体gConfig中
-	viper.Unmarshal(&gConfig)
-	configInstance = viper.GetViper()
+// 返回当前生效的配置，配置热加载时整体替换，返回的配置不能修改
+func GetConfig() *Configuration {
+	return current.Load()
+}
 
-	// 监听配置文件的变化，并将变化更新到gConfig中
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		fmt.Println("Config file changed:", e.Name)
-		viper.Unmarshal(&gConfig)
-	})
-	viper.WatchConfig()
-	return nil
+// MatchRoute 返回与模块及路径匹配的路由配置，多个路由匹配时取路径前缀最长的一个
+func (c *Configuration) MatchRoute(module string, path string) *RouteConfig {
+	var matched *RouteConfig
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if route.Module != module || !strings.HasPrefix(path, route.Path) {
+			continue
+		}
+		if matched == nil || len(route.Path) > len(matched.Path) {
+			matched = route
+		}
+	}
+	return matched
 }
 
-// 返回配置文件的实例
-func GetConfig() *Configuration {
-	return &gConfig
+// MatchService 返回gRPC服务名对应的路由配置
+func (c *Configuration) MatchService(service string) *RouteConfig {
+	for i := range c.Routes {
+		if c.Routes[i].Service != "" && c.Routes[i].Service == service {
+			return &c.Routes[i]
+		}
+	}
+	return nil
 }
 
-// 待完成
-func validateConfig(viper *viper.Viper) error {
+// MatchCanary 返回模块的灰度路由配置
+func (c *Configuration) MatchCanary(module string) *CanaryConfig {
+	for i := range c.Canaries {
+		if c.Canaries[i].Module == module {
+			return &c.Canaries[i]
+		}
+	}
 	return nil
 }
@@ -0,0 +1,37 @@
+// Package configtest 为其他包的测试加载配置，config包自身的测试不能引用该包
+package configtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ihub/pkg/config"
+)
+
+// Base 通过检查的最小配置，测试在其后追加需要的配置
+const Base = `
+server:
+  port: 30418
+DB:
+  NAME: "test"
+  HOST: "127.0.0.1"
+  PORT: 3306
+  USER: "root"
+  PASSWD: "passwd"
+  CHARSET: "utf8"
+  SM2PRIVATEFILE: "private.pem"
+runmode: "out"
+`
+
+// Init 将Base及extra写入临时目录并加载为当前配置。不监听配置文件，测试结束后不会留下监听的goroutine
+func Init(t testing.TB, extra string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ihub-config.yaml")
+	if err := os.WriteFile(file, []byte(Base+extra), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(config.Options{File: file, NoWatch: true}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ihub/pkg/kube"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// KubernetesConfig 从Kubernetes的ConfigMap或ApprovalRule读取审批映射，合并到配置文件之上、环境变量之下。
+// apiServer等连接配置只在启动时读取，修改后需要重启。
+type KubernetesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// APIServer apiserver地址，为空时使用集群内的KUBERNETES_SERVICE_HOST及KUBERNETES_SERVICE_PORT
+	APIServer string `yaml:"apiServer"`
+	// TokenFile、CAFile 为空时使用ServiceAccount的默认文件
+	TokenFile string `yaml:"tokenFile"`
+	CAFile    string `yaml:"caFile"`
+	// Namespace 为空时使用Pod所在的命名空间
+	Namespace string `yaml:"namespace"`
+	// ConfigMap 保存审批映射的ConfigMap名称，ConfigMapKey中的内容与配置文件中approveMap的格式相同
+	ConfigMap    string `yaml:"configMap"`
+	ConfigMapKey string `yaml:"configMapKey"`
+	// ApprovalRules 为true时读取命名空间中的ApprovalRule(ihub.io/v1)，每个ApprovalRule对应一个模块
+	ApprovalRules bool `yaml:"approvalRules"`
+}
+
+// DefaultConfigMapKey ConfigMap中审批映射的默认Key
+const DefaultConfigMapKey = "approveMap.yaml"
+
+// approvalRulePath ApprovalRule自定义资源的路径
+const approvalRulePath = "/apis/ihub.io/v1/namespaces/%s/approvalrules"
+
+// ApprovalRuleSpec ApprovalRule的spec，字段对应approveMap中以模块为key的各项
+type ApprovalRuleSpec struct {
+	// Module 模块名称
+	Module string `json:"module"`
+	// ModuleTrans 对应moduleTransMap
+	ModuleTrans string `json:"moduleTrans"`
+	// Operates、AdminOperates 对应moduleOperateMapGroup、moduleOperateMapAdmin
+	Operates      []string `json:"operates"`
+	AdminOperates []string `json:"adminOperates"`
+	// OperatorTrans 对应operatorTransMap
+	OperatorTrans map[string]string `json:"operatorTrans"`
+	// Endpoints 对应appstoreTransMap，接口路径转换为操作名称
+	Endpoints map[string]string `json:"endpoints"`
+}
+
+// layer 一个配置来源中的配置项，与viper.AllSettings的格式相同
+type layer struct {
+	source   string
+	settings map[string]interface{}
+}
+
+var (
+	kubeMu sync.Mutex
+	// kubeLayers 从Kubernetes读取的配置，key为configmap或approvalrule
+	kubeLayers = map[string][]layer{}
+	// kubeReady 启动时同步加载完成后，Kubernetes中的变化才触发热加载
+	kubeReady atomic.Bool
+)
+
+// kubernetesLayers 返回从Kubernetes读取的配置，ConfigMap在前，ApprovalRule按名称顺序在后
+func kubernetesLayers() []layer {
+	kubeMu.Lock()
+	defer kubeMu.Unlock()
+	return append(append([]layer(nil), kubeLayers["configmap"]...), kubeLayers["approvalrule"]...)
+}
+
+func setKubernetesLayers(kind string, layers []layer, opts Options, source string) {
+	kubeMu.Lock()
+	kubeLayers[kind] = layers
+	kubeMu.Unlock()
+	if kubeReady.Load() {
+		reload(opts, source)
+	}
+}
+
+// startKubernetes 同步读取一次ConfigMap及ApprovalRule，之后在后台watch。
+// 读取失败时只记录日志，继续使用配置文件中的审批映射，后台会重试。
+func startKubernetes(opts Options, cfg KubernetesConfig) error {
+	client, err := kube.NewClient(cfg.APIServer, cfg.TokenFile, cfg.CAFile)
+	if err != nil {
+		return err
+	}
+	ns := cfg.Namespace
+	if ns == "" {
+		ns = kube.Namespace()
+	}
+
+	var watchers []*kube.Watcher
+	if cfg.ConfigMap != "" {
+		key := cfg.ConfigMapKey
+		if key == "" {
+			key = DefaultConfigMapKey
+		}
+		source := "configmap " + ns + "/" + cfg.ConfigMap
+		watchers = append(watchers, &kube.Watcher{
+			Client: client,
+			Path:   "/api/v1/namespaces/" + ns + "/configmaps",
+			Query:  url.Values{"fieldSelector": {"metadata.name=" + cfg.ConfigMap}},
+			OnChange: func(objs []kube.Object) {
+				var layers []layer
+				for _, o := range objs {
+					l, err := configMapLayer(o, key, source)
+					if err != nil {
+						// 解析失败时保持原来的配置
+						logrus.WithFields(logrus.Fields{"source": source, "error": err}).Error("config not reloaded")
+						return
+					}
+					layers = append(layers, l)
+				}
+				setKubernetesLayers("configmap", layers, opts, source)
+			},
+		})
+	}
+	if cfg.ApprovalRules {
+		watchers = append(watchers, &kube.Watcher{
+			Client: client,
+			Path:   fmt.Sprintf(approvalRulePath, ns),
+			OnChange: func(objs []kube.Object) {
+				var layers []layer
+				for _, o := range objs {
+					l, err := approvalRuleLayer(o)
+					if err != nil {
+						// 格式错误的ApprovalRule跳过，不影响其他模块
+						logrus.WithFields(logrus.Fields{"name": o.Metadata.Name, "error": err}).Warn("invalid ApprovalRule ignored")
+						continue
+					}
+					layers = append(layers, l)
+				}
+				setKubernetesLayers("approvalrule", layers, opts, "approvalrules "+ns)
+			},
+		})
+	}
+
+	for _, w := range watchers {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		rv, err := w.Sync(ctx)
+		cancel()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"path": w.Path, "error": err}).Warn("load approval mappings from kubernetes failed, retrying in background")
+		}
+		go w.Run(context.Background(), rv)
+	}
+	return nil
+}
+
+// configMapLayer 解析ConfigMap中的审批映射，ConfigMap中没有该Key时为空
+func configMapLayer(o kube.Object, key string, source string) (layer, error) {
+	data, ok := o.Data[key]
+	if !ok {
+		return layer{source: source}, nil
+	}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(data)); err != nil {
+		return layer{}, fmt.Errorf("%s: %w", key, err)
+	}
+	return layer{source: source, settings: map[string]interface{}{"approvemap": v.AllSettings()}}, nil
+}
+
+// approvalRuleLayer 将ApprovalRule转换为approveMap中的配置项，与配置文件一样key使用小写
+func approvalRuleLayer(o kube.Object) (layer, error) {
+	var spec ApprovalRuleSpec
+	if err := json.Unmarshal(o.Spec, &spec); err != nil {
+		return layer{}, err
+	}
+	if spec.Module == "" {
+		return layer{}, fmt.Errorf("spec.module is required")
+	}
+	module := strings.ToLower(spec.Module)
+	am := map[string]interface{}{}
+	if spec.ModuleTrans != "" {
+		am["moduletransmap"] = map[string]interface{}{module: spec.ModuleTrans}
+	}
+	if spec.Operates != nil {
+		am["moduleoperatemapgroup"] = map[string]interface{}{module: toList(spec.Operates)}
+	}
+	if spec.AdminOperates != nil {
+		am["moduleoperatemapadmin"] = map[string]interface{}{module: toList(spec.AdminOperates)}
+	}
+	if len(spec.OperatorTrans) > 0 {
+		am["operatortransmap"] = lowerKeys(spec.OperatorTrans)
+	}
+	if len(spec.Endpoints) > 0 {
+		am["appstoretransmap"] = lowerKeys(spec.Endpoints)
+	}
+	return layer{
+		source:   "approvalrule " + o.Metadata.Namespace + "/" + o.Metadata.Name,
+		settings: map[string]interface{}{"approvemap": am},
+	}, nil
+}
+
+func toList(values []string) []interface{} {
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
+
+func lowerKeys(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ihub/pkg/kube"
+)
+
+// trans 返回多语言名称
+func trans(zh string, en string) string {
+	b, _ := json.Marshal(map[string]string{"zh-CN": zh, "en-US": en})
+	return string(b)
+}
+
+// kubeStub apiserver的替身，list返回初始对象，watch依次推送events中的事件
+type kubeStub struct {
+	lists  map[string]interface{}
+	events map[string]chan string
+	stop   chan struct{}
+}
+
+func (s *kubeStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("watch") != "true" {
+		json.NewEncoder(w).Encode(s.lists[r.URL.Path])
+		return
+	}
+	ch := s.events[r.URL.Path]
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case ev := <-ch:
+			fmt.Fprintln(w, ev)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func watchEvent(typ string, obj interface{}) string {
+	b, _ := json.Marshal(map[string]interface{}{"type": typ, "object": obj})
+	return string(b)
+}
+
+func approveConfigMap(rv string, appstore string) kube.Object {
+	return kube.Object{
+		Kind:     "ConfigMap",
+		Metadata: kube.ObjectMeta{Name: "ihub-approve", Namespace: "ns", ResourceVersion: rv},
+		Data: map[string]string{
+			DefaultConfigMapKey: "moduleTransMap:\n  appstore: '" + appstore + "'\n",
+		},
+	}
+}
+
+func approvalRule(rv string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":     "ApprovalRule",
+		"metadata": kube.ObjectMeta{Name: "datacenter", Namespace: "ns", ResourceVersion: rv},
+		"spec": ApprovalRuleSpec{
+			Module:        "DataCenter",
+			ModuleTrans:   trans("数据中心", "Data Center"),
+			Operates:      []string{"v1/dc/create"},
+			OperatorTrans: map[string]string{"V1/DC/Create": trans("创建", "Create")},
+		},
+	}
+}
+
+// waitConfig 等待热加载后的配置满足条件
+func waitConfig(t *testing.T, what string, ok func(*Configuration) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok(GetConfig()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("config not reloaded: %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKubernetesApproveMap(t *testing.T) {
+	configMapsPath := "/api/v1/namespaces/ns/configmaps"
+	rulesPath := fmt.Sprintf(approvalRulePath, "ns")
+	stub := &kubeStub{
+		lists: map[string]interface{}{
+			configMapsPath: map[string]interface{}{
+				"metadata": map[string]string{"resourceVersion": "10"},
+				"items":    []kube.Object{approveConfigMap("10", trans("应用商店", "App Store"))},
+			},
+			rulesPath: map[string]interface{}{
+				"metadata": map[string]string{"resourceVersion": "20"},
+				"items":    []interface{}{approvalRule("20")},
+			},
+		},
+		events: map[string]chan string{
+			configMapsPath: make(chan string, 1),
+			rulesPath:      make(chan string, 1),
+		},
+		stop: make(chan struct{}),
+	}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(stub.stop) })
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ihub-config.yaml")
+	yaml := `
+server:
+  port: 30418
+DB:
+  NAME: "test"
+  HOST: "127.0.0.1"
+  PORT: 3306
+  USER: "root"
+  PASSWD: "passwd"
+  CHARSET: "utf8"
+  SM2PRIVATEFILE: "private.pem"
+runmode: "out"
+approveMap:
+  moduleTransMap:
+    appstore: '` + trans("商店", "Store") + `'
+kubernetes:
+  enabled: true
+  apiServer: "` + srv.URL + `"
+  tokenFile: "` + filepath.Join(dir, "token") + `"
+  caFile: "` + filepath.Join(dir, "ca.crt") + `"
+  namespace: "ns"
+  configMap: "ihub-approve"
+  approvalRules: true
+`
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(Options{File: file, NoWatch: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 启动时同步读取，ConfigMap覆盖配置文件，ApprovalRule的key转换为小写
+	am := GetConfig().ApproveMap
+	if got := am.ModuleTransMap["appstore"]; got != trans("应用商店", "App Store") {
+		t.Errorf("moduleTransMap.appstore = %q, want the ConfigMap value", got)
+	}
+	if got := am.ModuleTransMap["datacenter"]; got != trans("数据中心", "Data Center") {
+		t.Errorf("moduleTransMap.datacenter = %q, want the ApprovalRule value", got)
+	}
+	if got := am.ModuleOperateMapGroup["datacenter"]; len(got) != 1 || got[0] != "v1/dc/create" {
+		t.Errorf("moduleOperateMapGroup.datacenter = %q, want [v1/dc/create]", got)
+	}
+	if _, ok := am.OperatorTransMap["v1/dc/create"]; !ok {
+		t.Errorf("operatorTransMap = %v, want v1/dc/create", am.OperatorTransMap)
+	}
+	sources := map[string]string{}
+	for _, s := range GetConfig().Settings() {
+		sources[s.Key] = s.Source
+	}
+	if got := sources["approvemap.moduletransmap.appstore"]; got != "configmap ns/ihub-approve" {
+		t.Errorf("source of approvemap.moduletransmap.appstore = %q", got)
+	}
+	if got := sources["approvemap.moduletransmap.datacenter"]; got != "approvalrule ns/datacenter" {
+		t.Errorf("source of approvemap.moduletransmap.datacenter = %q", got)
+	}
+
+	// watch到的变化触发热加载
+	stub.events[configMapsPath] <- watchEvent("MODIFIED", approveConfigMap("11", trans("应用市场", "App Market")))
+	waitConfig(t, "ConfigMap MODIFIED", func(c *Configuration) bool {
+		return c.ApproveMap.ModuleTransMap["appstore"] == trans("应用市场", "App Market")
+	})
+	stub.events[rulesPath] <- watchEvent("DELETED", approvalRule("21"))
+	waitConfig(t, "ApprovalRule DELETED", func(c *Configuration) bool {
+		_, ok := c.ApproveMap.ModuleTransMap["datacenter"]
+		return !ok && len(c.ApproveMap.ModuleOperateMapGroup["datacenter"]) == 0
+	})
+	// ConfigMap删除后恢复为配置文件中的值
+	stub.events[configMapsPath] <- watchEvent("DELETED", approveConfigMap("12", ""))
+	waitConfig(t, "ConfigMap DELETED", func(c *Configuration) bool {
+		return c.ApproveMap.ModuleTransMap["appstore"] == trans("商店", "Store")
+	})
+}
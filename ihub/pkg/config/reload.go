@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ihub/pkg/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 配置热加载的结果，用于日志及监控指标
+const (
+	ReloadSuccess  = "success"
+	ReloadFailure  = "failure"
+	ReloadRollback = "rollback"
+)
+
+// ErrNoPrevious 没有可以回滚的配置
+var ErrNoPrevious = errors.New("no previous config to roll back to")
+
+// ReloadEvent 配置切换事件，Old为切换前的配置，New为切换后的配置
+type ReloadEvent struct {
+	Result  string
+	Version uint64
+	Old     *Configuration
+	New     *Configuration
+}
+
+var (
+	// current 当前生效的配置，加载完成后不再修改，请求中通过GetConfig读取
+	current atomic.Pointer[Configuration]
+	// version 每次切换配置加1，回滚也会生成新的版本号
+	version atomic.Uint64
+
+	reloadMu sync.Mutex
+	// previous 上一个生效的配置，用于回滚
+	previous  *Configuration
+	listeners []func(ReloadEvent) error
+	// lastReload 最近一次加载的时间、结果及错误，用于管理接口
+	lastReload ReloadStatus
+)
+
+// ReloadStatus 最近一次加载配置的状态
+type ReloadStatus struct {
+	Version uint64    `json:"version"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+	// CanRollback 是否有可以回滚的上一个配置
+	CanRollback bool `json:"canRollback"`
+}
+
+// OnReload 注册配置切换后的回调，按注册顺序在切换后调用。
+// 回调返回错误时(如中间件链无法重建)回滚到切换前的配置。
+func OnReload(fn func(ReloadEvent) error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Status 返回当前配置的版本及最近一次加载的结果
+func Status() ReloadStatus {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	st := lastReload
+	st.Version = version.Load()
+	st.CanRollback = previous != nil
+	return st
+}
+
+// reload 重新读取全部配置来源并构造新的配置，检查通过后整体替换当前配置，检查失败时保持原配置
+func reload(opts Options, source string) error {
+	cfg, err := load(opts)
+	if err != nil {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		recordReload(ReloadFailure, err, source)
+		return err
+	}
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return swap(cfg, ReloadSuccess, source)
+}
+
+// Rollback 切换回上一个生效的配置，之后可以再次回滚回来
+func Rollback() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if previous == nil {
+		return ErrNoPrevious
+	}
+	return swap(previous, ReloadRollback, "rollback")
+}
+
+// swap 替换当前配置并通知回调，回调失败时恢复原配置，调用方需持有reloadMu
+func swap(cfg *Configuration, result string, source string) error {
+	old := current.Load()
+	current.Store(cfg)
+	ev := ReloadEvent{Result: result, Version: version.Add(1), Old: old, New: cfg}
+	for i, fn := range listeners {
+		if err := fn(ev); err != nil {
+			current.Store(old)
+			// 已经通知过的回调需要恢复到原配置
+			restore := ReloadEvent{Result: ReloadRollback, Version: version.Add(1), Old: cfg, New: old}
+			for _, fn := range listeners[:i] {
+				fn(restore)
+			}
+			recordReload(ReloadFailure, err, source)
+			return err
+		}
+	}
+	previous = old
+	recordReload(result, nil, source)
+	return nil
+}
+
+func recordReload(result string, err error, source string) {
+	lastReload = ReloadStatus{Result: result, Time: time.Now()}
+	metrics.ConfigReloads.WithLabelValues(result).Inc()
+	fields := logrus.Fields{"source": source, "result": result, "version": version.Load()}
+	if err != nil {
+		lastReload.Error = err.Error()
+		fields["error"] = err
+		logrus.WithFields(fields).Error("config not reloaded, keep the current config")
+		return
+	}
+	metrics.ConfigLastReload.SetToCurrentTime()
+	logrus.WithFields(fields).Info("config reloaded")
+}
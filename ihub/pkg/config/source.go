@@ -0,0 +1,348 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix 覆盖配置项的环境变量前缀，如IHUB_DB_HOST覆盖DB.HOST
+const EnvPrefix = "IHUB_"
+
+// Options 配置的来源，优先级从低到高依次为配置文件、配置目录中的文件、Kubernetes中的审批映射、环境变量
+type Options struct {
+	// File 主配置文件
+	File string
+	// ConfDir 配置目录，其中的*.yaml、*.yml按文件名顺序合并到主配置文件之上，
+	// 为空时使用主配置文件所在目录下的conf.d，目录不存在时忽略
+	ConfDir string
+	// NoWatch 为true时只加载一次，不监听配置文件及配置目录的变化，用于-dump-config及测试
+	NoWatch bool
+}
+
+// confDir 返回配置目录
+func (o Options) confDir() string {
+	if o.ConfDir != "" {
+		return o.ConfDir
+	}
+	return filepath.Join(filepath.Dir(o.File), "conf.d")
+}
+
+// confFiles 返回配置目录中按文件名排序的配置文件
+func (o Options) confFiles() ([]string, error) {
+	entries, err := os.ReadDir(o.confDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		// 跳过隐藏文件，如ConfigMap挂载时的..data及编辑器的临时文件
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		files = append(files, filepath.Join(o.confDir(), e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Setting 生效配置中的一项及其来源，来源为配置文件路径或环境变量名
+type Setting struct {
+	Key    string      `json:"key"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// secretPaths 带有secret:"true"标签的配置项，输出生效配置时隐藏，映射类型的配置项中每一项都隐藏
+var secretPaths = secretFields("", reflect.TypeOf(Configuration{}))
+
+// readLayers 依次读取配置文件、配置目录、Kubernetes中的审批映射及环境变量，返回合并后的配置及每一项的来源
+func readLayers(opts Options) (*viper.Viper, map[string]string, error) {
+	sources := map[string]string{}
+	v := viper.New()
+	v.SetConfigFile(opts.File)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, nil, err
+	}
+	markSources(sources, "", v.AllSettings(), opts.File)
+
+	files, err := opts.confFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range files {
+		layer := viper.New()
+		layer.SetConfigFile(f)
+		if err := layer.ReadInConfig(); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", f, err)
+		}
+		if err := v.MergeConfigMap(layer.AllSettings()); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", f, err)
+		}
+		markSources(sources, "", layer.AllSettings(), f)
+	}
+	for _, l := range kubernetesLayers() {
+		if len(l.settings) == 0 {
+			continue
+		}
+		if err := v.MergeConfigMap(l.settings); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", l.source, err)
+		}
+		markSources(sources, "", l.settings, l.source)
+	}
+
+	applyEnv(v, sources)
+	return v, sources, nil
+}
+
+// load 读取全部配置来源并检查，通过时返回新的配置
+func load(opts Options) (*Configuration, error) {
+	v, sources, err := readLayers(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig(v)
+	if err != nil {
+		return nil, err
+	}
+	cfg.settings = settings(v, sources)
+	return cfg, nil
+}
+
+// markSources 记录每个叶子配置项的来源，列表整体作为一项
+func markSources(sources map[string]string, prefix string, m map[string]interface{}, source string) {
+	for k, val := range m {
+		key := join(prefix, k)
+		if sub, ok := val.(map[string]interface{}); ok {
+			markSources(sources, key, sub, source)
+			continue
+		}
+		// 上层的标量覆盖下层的映射时，下层映射中的配置项不再生效
+		for old := range sources {
+			if strings.HasPrefix(old, key+".") {
+				delete(sources, old)
+			}
+		}
+		sources[key] = source
+	}
+}
+
+// applyEnv 用IHUB_前缀的环境变量覆盖配置项，变量名为配置项路径转为大写，"."及"-"替换为"_"。
+// 可以覆盖Configuration中的所有标量及字符串列表(逗号分隔)，以及配置文件中已有的映射项，如审批映射。
+func applyEnv(v *viper.Viper, sources map[string]string) {
+	keys := map[string]reflect.Type{}
+	for _, key := range v.AllKeys() {
+		keys[key] = nil
+		// 配置文件中已有的列表，如审批映射中模块的操作列表
+		if _, ok := v.Get(key).([]interface{}); ok {
+			keys[key] = reflect.TypeOf([]string{})
+		}
+	}
+	envKeys(keys, "", reflect.TypeOf(Configuration{}))
+	for _, key := range sortedKeys(keys) {
+		name := envName(key)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		v.Set(key, envValue(raw, keys[key]))
+		sources[key] = "env " + name
+	}
+}
+
+// envKeys 返回Configuration中可以用环境变量覆盖的配置项及其类型
+func envKeys(keys map[string]reflect.Type, prefix string, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key := join(prefix, strings.ToLower(f.Name))
+		ft := f.Type
+		switch {
+		case ft.Kind() == reflect.Struct && ft != durationType:
+			envKeys(keys, key, ft)
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.String:
+			keys[key] = ft
+		case ft.Kind() == reflect.Map || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Interface:
+			// 映射只能覆盖配置文件中已有的项，结构列表不能用环境变量覆盖
+		default:
+			keys[key] = ft
+		}
+	}
+}
+
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// envValue 将环境变量转换为配置项的类型，使类型检查与配置文件中的写法一致
+func envValue(raw string, t reflect.Type) interface{} {
+	if t != nil && t.Kind() == reflect.Slice {
+		var list []interface{}
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	if t == nil || t.Kind() == reflect.String || t == durationType {
+		return raw
+	}
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return int(n)
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(raw); err == nil {
+		return b
+	}
+	return raw
+}
+
+// settings 返回合并后的每一项配置及其来源，密码等敏感配置项隐藏
+func settings(v *viper.Viper, sources map[string]string) []Setting {
+	all := v.AllSettings()
+	var list []Setting
+	for _, key := range sortedKeys(sources) {
+		val := lookup(all, key)
+		if isSecret(key) && val != nil && fmt.Sprint(val) != "" {
+			val = "******"
+		}
+		list = append(list, Setting{Key: key, Value: val, Source: sources[key]})
+	}
+	return list
+}
+
+func lookup(m map[string]interface{}, key string) interface{} {
+	var cur interface{} = m
+	for _, part := range strings.Split(key, ".") {
+		sub, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = sub[part]
+	}
+	return cur
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, p := range secretPaths {
+		if key == p || strings.HasPrefix(key, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// secretFields 返回结构中带有secret:"true"标签的配置项路径，路径与viper中的key一致为小写
+func secretFields(prefix string, t reflect.Type) []string {
+	var paths []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key := join(prefix, strings.ToLower(f.Name))
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch {
+		case f.Tag.Get("secret") == "true":
+			paths = append(paths, key)
+		case ft.Kind() == reflect.Struct && ft != durationType:
+			paths = append(paths, secretFields(key, ft)...)
+		}
+	}
+	return paths
+}
+
+// Settings 返回生效配置中的每一项及其来源，敏感配置项已经隐藏
+func (c *Configuration) Settings() []Setting {
+	return c.settings
+}
+
+// DumpSettings 按"配置项 = 值  # 来源"的格式输出生效配置
+func DumpSettings(list []Setting) string {
+	var b strings.Builder
+	for _, s := range list {
+		val, err := json.Marshal(s.Value)
+		if err != nil {
+			val = []byte(fmt.Sprint(s.Value))
+		}
+		fmt.Fprintf(&b, "%s = %s  # %s\n", s.Key, val, s.Source)
+	}
+	return b.String()
+}
+
+// watch 监听配置文件及配置目录的变化，短时间内的多次变化合并为一次加载。
+// 配置文件所在的目录整体监听，兼容编辑器先写临时文件再重命名及ConfigMap替换..data的方式。
+func watch(opts Options) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file, _ := filepath.Abs(opts.File)
+	confDir, _ := filepath.Abs(opts.confDir())
+	if err := w.Add(filepath.Dir(file)); err != nil {
+		w.Close()
+		return err
+	}
+	if confDir != filepath.Dir(file) {
+		// 配置目录不存在时不监听，启动后新建的配置目录需要重启生效
+		if _, err := os.Stat(confDir); err == nil {
+			if err := w.Add(confDir); err != nil {
+				w.Close()
+				return err
+			}
+		}
+	}
+
+	go func() {
+		timer := time.NewTimer(time.Hour)
+		timer.Stop()
+		var changed string
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				name, _ := filepath.Abs(e.Name)
+				relevant := name == file || filepath.Base(name) == "..data" || filepath.Dir(name) == confDir
+				if !relevant || e.Op == fsnotify.Chmod {
+					continue
+				}
+				changed = e.Name
+				timer.Reset(100 * time.Millisecond)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logrus.WithField("error", err).Warn("watch config failed")
+			case <-timer.C:
+				fmt.Println("Config file changed:", changed)
+				reload(opts, changed)
+			}
+		}
+	}()
+	return nil
+}
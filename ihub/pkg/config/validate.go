@@ -0,0 +1,740 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ihub/pkg/constants"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 可选值，与balancer、ratelimit等包中的定义一致
+var (
+	validRunmodes       = []string{constants.RunmodeIn, constants.RunmodeOut}
+	validStrategies     = []string{"", "round-robin", "least-conn", "consistent-hash"}
+	validHashKeys       = []string{"", "user", "trace"}
+	validRateLimitKeys  = []string{"user", "group", "ip", "cluster", "module"}
+	validBackends       = []string{"", "memory", "redis"}
+	validCacheScopes    = []string{"", "user", "group"}
+	validCanaryStickies = []string{"", "user", "cookie"}
+	validTransLanguages = []string{"zh-CN", "en-US"}
+	validLogFormats     = []string{"", "text", "json"}
+	validSamplers       = []string{"", "always_on", "always_off", "traceidratio", "parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio"}
+	durationType        = reflect.TypeOf(time.Duration(0))
+	// authVerifiesIdentity Auth中间件是否校验令牌并设置VerifiedUserID、VerifiedGroupID。
+	// 按用户或组缓存、灰度路由按用户分配依赖该身份，Auth实现令牌校验之前配置这些项会报错，避免配置了却从不生效
+	authVerifiesIdentity = false
+	// statusPattern 状态码(404)或状态码类别(5xx)
+	statusPattern = regexp.MustCompile(`(?i)^[1-5]([0-9]{2}|xx)$`)
+)
+
+// midwareNames 返回已注册的中间件名称，由midware包通过SetMidwareNames设置，未设置时不检查中间件名称
+var midwareNames func() []string
+
+// SetMidwareNames 设置检查配置文件时使用的中间件名称，config不能引用midware包，由midware包在初始化时设置
+func SetMidwareNames(f func() []string) {
+	midwareNames = f
+}
+
+// ValidationErrors 配置文件中的全部错误，每个错误以YAML路径开头
+type ValidationErrors []string
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return "invalid config: " + e[0]
+	}
+	return fmt.Sprintf("invalid config (%d errors):\n  %s", len(e), strings.Join(e, "\n  "))
+}
+
+// add 记录一个错误
+func (e *ValidationErrors) add(path string, format string, args ...interface{}) {
+	*e = append(*e, path+": "+fmt.Sprintf(format, args...))
+}
+
+// loadConfig 检查配置文件的类型、取值范围及各部分之间的引用，全部通过时返回新的配置，否则返回全部错误
+func loadConfig(v *viper.Viper) (*Configuration, error) {
+	var errs ValidationErrors
+	// 类型错误的值从配置中去掉，按零值反序列化，一次报告类型错误及其他取值、引用错误
+	settings := v.AllSettings()
+	checkTypes(&errs, "", reflect.TypeOf(Configuration{}), settings)
+	lenient := viper.New()
+	var cfg Configuration
+	err := lenient.MergeConfigMap(settings)
+	if err == nil {
+		err = lenient.Unmarshal(&cfg)
+	}
+	if err != nil {
+		errs.add("(root)", "%v", err)
+		return nil, errs
+	}
+	// 类型错误的配置项及其下层按零值检查时不再重复报告
+	typeErrs := append(ValidationErrors{}, errs...)
+	var valueErrs ValidationErrors
+	cfg.validate(&valueErrs)
+	for _, e := range valueErrs {
+		if !typeErrs.covers(errorPath(e)) {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &cfg, nil
+}
+
+// errorPath 返回错误开头的YAML路径
+func errorPath(e string) string {
+	path, _, _ := strings.Cut(e, ": ")
+	return path
+}
+
+// covers 判断path或其上层是否已经有错误
+func (e ValidationErrors) covers(path string) bool {
+	for _, err := range e {
+		p := errorPath(err)
+		if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTypes 按Configuration的结构检查配置文件中每个值的类型，并报告未知的字段。
+// viper会把key转换为小写，字段名不区分大小写匹配，与Unmarshal的行为一致。
+// 返回false时value的类型错误，调用方将其从配置中去掉；映射及列表中类型错误的项在这里去掉
+func checkTypes(errs *ValidationErrors, path string, t reflect.Type, value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		switch v := value.(type) {
+		case int, int64:
+		case string:
+			if _, err := time.ParseDuration(v); err != nil {
+				errs.add(path, "must be a duration like \"30s\", got %q", v)
+				return false
+			}
+		default:
+			errs.add(path, "must be a duration like \"30s\", got %s", describe(value))
+			return false
+		}
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be a mapping, got %s", describe(value))
+			return false
+		}
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				fields[strings.ToLower(t.Field(i).Name)] = t.Field(i)
+			}
+		}
+		for _, key := range sortedKeys(m) {
+			f, ok := fields[key]
+			if !ok {
+				errs.add(join(path, key), "unknown field")
+				delete(m, key)
+				continue
+			}
+			if !checkTypes(errs, join(path, fieldName(f)), f.Type, m[key]) {
+				delete(m, key)
+			}
+		}
+	case reflect.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be a mapping, got %s", describe(value))
+			return false
+		}
+		for _, key := range sortedKeys(m) {
+			if !checkTypes(errs, join(path, key), t.Elem(), m[key]) {
+				delete(m, key)
+			}
+		}
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			errs.add(path, "must be a list, got %s", describe(value))
+			return false
+		}
+		// 保留列表项的位置，之后的错误路径中的下标不变
+		for i, item := range list {
+			if !checkTypes(errs, fmt.Sprintf("%s[%d]", path, i), t.Elem(), item) {
+				list[i] = nil
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch value.(type) {
+		case int, int64, uint64:
+		default:
+			errs.add(path, "must be an integer, got %s", describe(value))
+			return false
+		}
+	case reflect.Float32, reflect.Float64:
+		switch value.(type) {
+		case int, int64, uint64, float64:
+		default:
+			errs.add(path, "must be a number, got %s", describe(value))
+			return false
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			errs.add(path, "must be true or false, got %s", describe(value))
+			return false
+		}
+	case reflect.String:
+		// 数字、布尔值可以作为字符串使用，如outerServicePortMap中的端口
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			errs.add(path, "must be a string, got %s", describe(value))
+			return false
+		}
+	}
+	return true
+}
+
+// validate 检查取值范围及各部分之间的引用
+func (c *Configuration) validate(errs *ValidationErrors) {
+	checkPort(errs, "server.port", c.SERVER.Port, true)
+	nonNegative(errs, "server.maxBodyBytes", c.SERVER.MaxBodyBytes)
+
+	// 数据库
+	required(errs, "DB.NAME", c.DB.Name)
+	required(errs, "DB.HOST", c.DB.Host)
+	required(errs, "DB.USER", c.DB.User)
+	required(errs, "DB.PASSWD", c.DB.Passwd)
+	required(errs, "DB.CHARSET", c.DB.Charset)
+	required(errs, "DB.SM2PRIVATEFILE", c.DB.SM2PrivateFile)
+	checkPort(errs, "DB.PORT", c.DB.Port, true)
+	nonNegative(errs, "DB.MAXOPENCONNS", int64(c.DB.MaxOpenConns))
+	nonNegative(errs, "DB.MAXIDLECONNS", int64(c.DB.MaxIdleConns))
+
+	// 日志
+	if c.LOG.Level != "" {
+		if _, err := logrus.ParseLevel(c.LOG.Level); err != nil {
+			errs.add("log.level", "unknown level %q", c.LOG.Level)
+		}
+	}
+	nonNegative(errs, "log.maxCaptureBytes", int64(c.LOG.MaxCaptureBytes))
+	oneOf(errs, "log.format", c.LOG.Format, validLogFormats)
+	for _, module := range sortedKeys(c.LOG.Modules) {
+		if _, err := logrus.ParseLevel(c.LOG.Modules[module]); err != nil {
+			errs.add("log.modules."+module, "unknown level %q", c.LOG.Modules[module])
+		}
+	}
+	nonNegative(errs, "log.rotate.maxSize", c.LOG.Rotate.MaxSize)
+	nonNegative(errs, "log.rotate.interval", int64(c.LOG.Rotate.Interval))
+	nonNegative(errs, "log.rotate.maxBackups", int64(c.LOG.Rotate.MaxBackups))
+	nonNegative(errs, "log.rotate.maxAge", int64(c.LOG.Rotate.MaxAge))
+	nonNegative(errs, "log.slowThreshold", int64(c.LOG.SlowThreshold))
+	for i, rule := range c.LOG.Sampling {
+		p := fmt.Sprintf("log.sampling[%d]", i)
+		for j, status := range rule.Statuses {
+			if !statusPattern.MatchString(status) {
+				errs.add(fmt.Sprintf("%s.statuses[%d]", p, j), "must be a status code or class like 404 or 5xx, got %q", status)
+			}
+		}
+		ratio(errs, p+".rate", rule.Rate)
+	}
+
+	oneOf(errs, "runmode", c.Runmode, validRunmodes)
+	c.validateMidwares(errs)
+	c.validateApproveMap(errs)
+	c.validateProxy(errs)
+	c.validateRoutes(errs)
+	c.validateCanaries(errs)
+
+	// 缓存
+	nonNegative(errs, "cache.maxEntries", int64(c.CACHE.MaxEntries))
+	nonNegative(errs, "cache.maxEntryBytes", c.CACHE.MaxEntryBytes)
+	nonNegative(errs, "cache.maxStale", int64(c.CACHE.MaxStale))
+
+	// 健康检查
+	if c.Health.Enabled {
+		positive(errs, "health.interval", int64(c.Health.Interval))
+		positive(errs, "health.timeout", int64(c.Health.Timeout))
+		for i, m := range c.Health.Modules {
+			path := fmt.Sprintf("health.modules[%d]", i)
+			required(errs, path+".module", m.Module)
+			checkURLPath(errs, path+".path", m.Path)
+		}
+	}
+	checkURLPath(errs, "health.clusterPath", c.Health.ClusterPath)
+	nonNegative(errs, "health.unhealthyThreshold", int64(c.Health.UnhealthyThreshold))
+	nonNegative(errs, "health.healthyThreshold", int64(c.Health.HealthyThreshold))
+
+	// 负载均衡
+	oneOf(errs, "balance.strategy", c.Balance.Strategy, validStrategies)
+	oneOf(errs, "balance.hashKey", c.Balance.HashKey, validHashKeys)
+	for i, cl := range c.Balance.Clusters {
+		path := fmt.Sprintf("balance.clusters[%d]", i)
+		required(errs, path+".name", cl.Name)
+		oneOf(errs, path+".strategy", cl.Strategy, validStrategies)
+		oneOf(errs, path+".hashKey", cl.HashKey, validHashKeys)
+	}
+
+	// 限流
+	oneOf(errs, "rateLimit.backend", c.RateLimit.Backend, validBackends)
+	if c.RateLimit.Backend == "redis" {
+		required(errs, "rateLimit.redis.addr", c.RateLimit.Redis.Addr)
+	}
+	nonNegative(errs, "rateLimit.redis.db", int64(c.RateLimit.Redis.DB))
+	validateRateLimitRules(errs, "rateLimit.rules", c.RateLimit.Rules)
+
+	// 跨域
+	validateCORS(errs, "cors", c.CORS)
+
+	// 监控指标
+	nonNegative(errs, "metrics.maxClusters", int64(c.Metrics.MaxClusters))
+	nonNegative(errs, "metrics.maxModules", int64(c.Metrics.MaxModules))
+	nonNegative(errs, "metrics.maxEndpoints", int64(c.Metrics.MaxEndpoints))
+	nonNegative(errs, "metrics.endpointDepth", int64(c.Metrics.EndpointDepth))
+
+	// 分布式追踪
+	if c.Tracing.Endpoint != "" {
+		checkTargetURL(errs, "tracing.endpoint", c.Tracing.Endpoint)
+	}
+	oneOf(errs, "tracing.sampler", c.Tracing.Sampler, validSamplers)
+	ratio(errs, "tracing.sampleRatio", c.Tracing.SampleRatio)
+	nonNegative(errs, "tracing.batchSize", int64(c.Tracing.BatchSize))
+	nonNegative(errs, "tracing.flushInterval", int64(c.Tracing.FlushInterval))
+	nonNegative(errs, "tracing.timeout", int64(c.Tracing.Timeout))
+	nonNegative(errs, "tracing.queueSize", int64(c.Tracing.QueueSize))
+
+	// 管理接口
+	if c.Admin.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Addr); err != nil {
+			errs.add("admin.addr", "must be host:port, got %q", c.Admin.Addr)
+		} else if !isLoopback(c.Admin.Addr) && len(c.Admin.Tokens) == 0 {
+			errs.add("admin.tokens", "is required when admin.addr %q is not a loopback address", c.Admin.Addr)
+		}
+	}
+	for _, name := range sortedKeys(c.Admin.Tokens) {
+		required(errs, "admin.tokens."+name, c.Admin.Tokens[name])
+	}
+
+	// Kubernetes中的审批映射
+	if k := c.Kubernetes; k.Enabled {
+		if k.ConfigMap == "" && !k.ApprovalRules {
+			errs.add("kubernetes", "configMap or approvalRules is required when enabled")
+		}
+		if k.APIServer != "" {
+			checkTargetURL(errs, "kubernetes.apiServer", k.APIServer)
+		}
+	}
+}
+
+// validateMidwares 中间件名称必须是midware包中注册的名称
+func (c *Configuration) validateMidwares(errs *ValidationErrors) {
+	seen := map[string]bool{}
+	for i, mw := range c.Midwares {
+		path := fmt.Sprintf("midwares[%d].midware", i)
+		if names := registeredMidwares(); names != nil && !contains(names, mw.Midware) {
+			errs.add(path, "unknown midware %q, must be one of %s", mw.Midware, strings.Join(names, ", "))
+		} else if seen[mw.Midware] {
+			errs.add(path, "midware %q is listed more than once", mw.Midware)
+		}
+		seen[mw.Midware] = true
+		for j, p := range mw.SkipPaths {
+			if !strings.HasPrefix(p, "/") {
+				errs.add(fmt.Sprintf("midwares[%d].skipPaths[%d]", i, j), "must start with \"/\", got %q", p)
+			}
+		}
+	}
+}
+
+func registeredMidwares() []string {
+	if midwareNames == nil {
+		return nil
+	}
+	return midwareNames()
+}
+
+// validateApproveMap 检查审批配置之间的引用：
+// 需要审批的模块必须有moduleTransMap，操作及appstoreTransMap转换后的操作必须有operatorTransMap。
+// viper会把map的key转换为小写，引用按小写比较。
+func (c *Configuration) validateApproveMap(errs *ValidationErrors) {
+	am := c.ApproveMap
+	for _, key := range sortedKeys(am.ModuleTransMap) {
+		checkTrans(errs, "approveMap.moduleTransMap."+key, am.ModuleTransMap[key])
+	}
+	for _, key := range sortedKeys(am.OperatorTransMap) {
+		checkTrans(errs, "approveMap.operatorTransMap."+key, am.OperatorTransMap[key])
+	}
+	for _, operateMap := range []struct {
+		name string
+		m    map[string][]string
+	}{
+		{"moduleOperateMapAdmin", am.ModuleOperateMapAdmin},
+		{"moduleOperateMapGroup", am.ModuleOperateMapGroup},
+	} {
+		name, m := operateMap.name, operateMap.m
+		for _, module := range sortedKeys(m) {
+			path := "approveMap." + name + "." + module
+			if _, ok := am.ModuleTransMap[strings.ToLower(module)]; !ok {
+				errs.add(path, "module %q has no entry in approveMap.moduleTransMap", module)
+			}
+			for i, operate := range m[module] {
+				if _, ok := am.OperatorTransMap[strings.ToLower(operate)]; !ok {
+					errs.add(fmt.Sprintf("%s[%d]", path, i), "operate %q has no entry in approveMap.operatorTransMap", operate)
+				}
+			}
+		}
+	}
+	for _, endpoint := range sortedKeys(am.AppstoreTransMap) {
+		operate := am.AppstoreTransMap[endpoint]
+		if _, ok := am.OperatorTransMap[strings.ToLower(operate)]; !ok {
+			errs.add("approveMap.appstoreTransMap."+endpoint, "operate %q has no entry in approveMap.operatorTransMap", operate)
+		}
+	}
+	for _, module := range sortedKeys(am.OuterServicePortMap) {
+		port, err := strconv.Atoi(am.OuterServicePortMap[module])
+		if err != nil {
+			errs.add("approveMap.outerServicePortMap."+module, "must be a port number, got %q", am.OuterServicePortMap[module])
+			continue
+		}
+		checkPort(errs, "approveMap.outerServicePortMap."+module, port, true)
+	}
+}
+
+// checkTrans 多语言名称为JSON对象，必须包含每种语言
+func checkTrans(errs *ValidationErrors, path string, value string) {
+	var names map[string]string
+	if err := json.Unmarshal([]byte(value), &names); err != nil {
+		errs.add(path, "must be a JSON object like {\"zh-CN\": \"...\", \"en-US\": \"...\"}: %v", err)
+		return
+	}
+	for _, lang := range validTransLanguages {
+		if names[lang] == "" {
+			errs.add(path, "missing %q name", lang)
+		}
+	}
+}
+
+func (c *Configuration) validateProxy(errs *ValidationErrors) {
+	validateRetry(errs, "proxy.retry", c.Proxy.Retry)
+
+	b := c.Proxy.Breaker
+	if b.Enabled {
+		positive(errs, "proxy.breaker.window", int64(b.Window))
+		positive(errs, "proxy.breaker.openDuration", int64(b.OpenDuration))
+	}
+	ratio(errs, "proxy.breaker.failureRatio", b.FailureRatio)
+	nonNegative(errs, "proxy.breaker.minRequests", int64(b.MinRequests))
+	nonNegative(errs, "proxy.breaker.halfOpenProbes", int64(b.HalfOpenProbes))
+
+	validateConcurrency(errs, "proxy.concurrency", c.Proxy.Concurrency)
+	s := c.Proxy.Shedding
+	nonNegative(errs, "proxy.shedding.maxInflight", int64(s.MaxInflight))
+	nonNegative(errs, "proxy.shedding.lowPriorityInflight", int64(s.LowPriorityInflight))
+	if s.MaxInflight > 0 && s.LowPriorityInflight > s.MaxInflight {
+		errs.add("proxy.shedding.lowPriorityInflight", "must not exceed proxy.shedding.maxInflight (%d)", s.MaxInflight)
+	}
+	for i, name := range c.Proxy.StripHeaders {
+		required(errs, fmt.Sprintf("proxy.stripHeaders[%d]", i), name)
+	}
+}
+
+func validateRetry(errs *ValidationErrors, path string, r RetryConfig) {
+	nonNegative(errs, path+".attempts", int64(r.Attempts))
+	nonNegative(errs, path+".backoff", int64(r.Backoff))
+	nonNegative(errs, path+".maxBackoff", int64(r.MaxBackoff))
+	if r.MaxBackoff > 0 && r.Backoff > r.MaxBackoff {
+		errs.add(path+".backoff", "must not exceed maxBackoff (%s)", r.MaxBackoff)
+	}
+	for i, status := range r.Statuses {
+		if status < 100 || status > 599 {
+			errs.add(fmt.Sprintf("%s.statuses[%d]", path, i), "must be an HTTP status code, got %d", status)
+		}
+	}
+	nonNegative(errs, path+".maxBodyBytes", r.MaxBodyBytes)
+	ratio(errs, path+".budgetRatio", r.BudgetRatio)
+	nonNegative(errs, path+".minRetries", int64(r.MinRetries))
+}
+
+func validateConcurrency(errs *ValidationErrors, path string, cc ConcurrencyConfig) {
+	nonNegative(errs, path+".maxInflight", int64(cc.MaxInflight))
+	nonNegative(errs, path+".queueSize", int64(cc.QueueSize))
+	nonNegative(errs, path+".queueTimeout", int64(cc.QueueTimeout))
+	nonNegative(errs, path+".minInflight", int64(cc.MinInflight))
+	if cc.MaxInflight > 0 && cc.MinInflight > cc.MaxInflight {
+		errs.add(path+".minInflight", "must not exceed maxInflight (%d)", cc.MaxInflight)
+	}
+	if cc.QueueSize > 0 && cc.QueueTimeout <= 0 {
+		errs.add(path+".queueTimeout", "must be positive when queueSize is set")
+	}
+}
+
+func validateRateLimitRules(errs *ValidationErrors, path string, rules []RateLimitRule) {
+	for i, rule := range rules {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		if !contains(validRateLimitKeys, rule.Key) {
+			errs.add(p+".key", "unknown key %q, must be one of %s", rule.Key, strings.Join(validRateLimitKeys, ", "))
+		}
+		if rule.Rate <= 0 {
+			errs.add(p+".rate", "must be positive, got %v", rule.Rate)
+		}
+		nonNegative(errs, p+".burst", int64(rule.Burst))
+	}
+}
+
+func validateCORS(errs *ValidationErrors, path string, cors CORSConfig) {
+	for i, origin := range cors.AllowOrigins {
+		p := fmt.Sprintf("%s.allowOrigins[%d]", path, i)
+		if origin == "*" || strings.HasPrefix(origin, "*.") {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs.add(p, "must be *, *.domain or an origin like https://example.com, got %q", origin)
+		}
+	}
+	nonNegative(errs, path+".maxAge", int64(cors.MaxAge))
+}
+
+func (c *Configuration) validateRoutes(errs *ValidationErrors) {
+	seen := map[string]int{}
+	services := map[string]int{}
+	for i, r := range c.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		required(errs, path+".module", r.Module)
+		if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+			errs.add(path+".path", "must start with /, got %q", r.Path)
+		}
+		if j, ok := seen[r.Module+"\x00"+r.Path]; ok {
+			errs.add(path, "duplicates routes[%d] (module %q, path %q)", j, r.Module, r.Path)
+		} else {
+			seen[r.Module+"\x00"+r.Path] = i
+		}
+		if r.Service != "" {
+			if j, ok := services[r.Service]; ok {
+				errs.add(path+".service", "service %q is already routed by routes[%d]", r.Service, j)
+			} else {
+				services[r.Service] = i
+			}
+		}
+		nonNegative(errs, path+".maxBodyBytes", r.MaxBodyBytes)
+		validateRateLimitRules(errs, path+".rateLimits", r.RateLimits)
+		if r.Retry != nil {
+			validateRetry(errs, path+".retry", *r.Retry)
+		}
+		if r.Concurrency != nil {
+			validateConcurrency(errs, path+".concurrency", *r.Concurrency)
+		}
+		if r.Cache != nil {
+			nonNegative(errs, path+".cache.ttl", int64(r.Cache.TTL))
+			oneOf(errs, path+".cache.scope", r.Cache.Scope, validCacheScopes)
+			if r.Cache.Scope != "" && !authVerifiesIdentity {
+				errs.add(path+".cache.scope", "scope %q needs the identity verified by Auth, which does not verify tokens yet", r.Cache.Scope)
+			}
+		}
+		if m := r.Mirror; m != nil {
+			if m.Target == "" && m.Cluster == "" {
+				errs.add(path+".mirror", "one of cluster or target is required")
+			}
+			if m.Target != "" {
+				checkTargetURL(errs, path+".mirror.target", m.Target)
+			}
+			if m.Percent < 0 || m.Percent > 100 {
+				errs.add(path+".mirror.percent", "must be between 0 and 100, got %v", m.Percent)
+			}
+			nonNegative(errs, path+".mirror.timeout", int64(m.Timeout))
+			nonNegative(errs, path+".mirror.maxBodyBytes", m.MaxBodyBytes)
+		}
+		if rw := r.Rewrite; rw != nil {
+			if rw.Path != nil {
+				if _, err := regexp.Compile(rw.Path.Pattern); err != nil {
+					errs.add(path+".rewrite.path.pattern", "invalid regular expression: %v", err)
+				}
+			}
+			for j, kv := range rw.Query {
+				required(errs, fmt.Sprintf("%s.rewrite.query[%d].name", path, j), kv.Name)
+			}
+		}
+		if r.CORS != nil {
+			validateCORS(errs, path+".cors", *r.CORS)
+		}
+		nonNegative(errs, path+".slowThreshold", int64(r.SlowThreshold))
+	}
+}
+
+func (c *Configuration) validateCanaries(errs *ValidationErrors) {
+	seen := map[string]int{}
+	for i, cn := range c.Canaries {
+		path := fmt.Sprintf("canaries[%d]", i)
+		required(errs, path+".module", cn.Module)
+		if j, ok := seen[cn.Module]; ok {
+			errs.add(path+".module", "module %q already has canary rules in canaries[%d]", cn.Module, j)
+		} else {
+			seen[cn.Module] = i
+		}
+		if len(cn.Versions) == 0 {
+			errs.add(path+".versions", "is required")
+		}
+		versions := map[string]bool{}
+		for j, v := range cn.Versions {
+			p := fmt.Sprintf("%s.versions[%d]", path, j)
+			required(errs, p+".module", v.Module)
+			nonNegative(errs, p+".weight", int64(v.Weight))
+			versions[v.Module] = true
+		}
+		oneOf(errs, path+".sticky", cn.Sticky, validCanaryStickies)
+		if cn.Sticky == "user" && !authVerifiesIdentity {
+			errs.add(path+".sticky", "sticky \"user\" needs the identity verified by Auth, which does not verify tokens yet")
+		}
+		if len(cn.Users) > 0 && !authVerifiesIdentity {
+			errs.add(path+".users", "needs the identity verified by Auth, which does not verify tokens yet")
+		}
+		for _, user := range sortedKeys(cn.Users) {
+			if !versions[cn.Users[user]] {
+				errs.add(path+".users."+user, "version %q is not listed in versions", cn.Users[user])
+			}
+		}
+	}
+}
+
+// checkTargetURL 目标地址必须是带主机名的http或https地址
+func checkTargetURL(errs *ValidationErrors, path string, target string) {
+	u, err := url.Parse(target)
+	if err != nil {
+		errs.add(path, "invalid URL: %v", err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		errs.add(path, "must be an http or https URL, got %q", target)
+	} else if u.Host == "" {
+		errs.add(path, "must include a host, got %q", target)
+	}
+}
+
+// isLoopback 判断监听地址是否只监听本机，主机为空时监听所有地址
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func checkURLPath(errs *ValidationErrors, path string, value string) {
+	if value != "" && !strings.HasPrefix(value, "/") {
+		errs.add(path, "must start with /, got %q", value)
+	}
+}
+
+func checkPort(errs *ValidationErrors, path string, port int, isRequired bool) {
+	if port == 0 && isRequired {
+		errs.add(path, "is required")
+	} else if port < 0 || port > 65535 {
+		errs.add(path, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+func required(errs *ValidationErrors, path string, value string) {
+	if strings.TrimSpace(value) == "" {
+		errs.add(path, "is required")
+	}
+}
+
+func nonNegative(errs *ValidationErrors, path string, value int64) {
+	if value < 0 {
+		errs.add(path, "must not be negative, got %d", value)
+	}
+}
+
+func positive(errs *ValidationErrors, path string, value int64) {
+	if value <= 0 {
+		errs.add(path, "must be positive")
+	}
+}
+
+func ratio(errs *ValidationErrors, path string, value float64) {
+	if value < 0 || value > 1 {
+		errs.add(path, "must be between 0 and 1, got %v", value)
+	}
+}
+
+func oneOf(errs *ValidationErrors, path string, value string, valid []string) {
+	if contains(valid, value) {
+		return
+	}
+	var names []string
+	for _, v := range valid {
+		if v != "" {
+			names = append(names, strconv.Quote(v))
+		}
+	}
+	errs.add(path, "unknown value %q, must be one of %s", value, strings.Join(names, ", "))
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// describe 描述YAML中值的类型，用于错误信息
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprintf("bool %v", v)
+	case int, int64, uint64:
+		return fmt.Sprintf("integer %v", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case map[string]interface{}:
+		return "mapping"
+	case []interface{}:
+		return "list"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// fieldName 返回字段在YAML中的名称
+func fieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("yaml"), ",")[0]; tag != "" {
+		return tag
+	}
+	return f.Name
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ihub/pkg/constants"
+
+	"github.com/spf13/viper"
+)
+
+// validBase 通过检查的最小配置，各用例在其后追加或覆盖配置
+const validBase = `
+server:
+  port: 30418
+DB:
+  NAME: "test"
+  HOST: "127.0.0.1"
+  PORT: 3306
+  USER: "root"
+  PASSWD: "passwd"
+  CHARSET: "utf8"
+  SM2PRIVATEFILE: "private.pem"
+runmode: "out"
+`
+
+// validateYAML 检查YAML配置，返回全部错误
+func validateYAML(t *testing.T, yaml string) ValidationErrors {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadConfig(v)
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("loadConfig returned %T %v, want ValidationErrors", err, err)
+	}
+	return errs
+}
+
+func TestValidateConfig(t *testing.T) {
+	// 中间件名称由midware包注册，config包的测试中使用内置的中间件
+	SetMidwareNames(func() []string {
+		return []string{constants.MidwareLog, constants.MidwareTrace, constants.MidwareInOut, constants.MidwareAuth}
+	})
+	defer SetMidwareNames(nil)
+	const trans = `'{"zh-CN": "应用商店", "en-US": "App Store"}'`
+	tests := []struct {
+		name string
+		yaml string
+		// want 每个错误的开头，数量及顺序需要一致
+		want []string
+	}{
+		{"valid", validBase, nil},
+		{"string port", strings.Replace(validBase, "port: 30418", `port: "30418"`, 1), []string{
+			`server.port: must be an integer, got string "30418"`,
+		}},
+		{"unknown midware", validBase + `
+midwares:
+- midware: "logger"
+`, []string{
+			`midwares[0].midware: unknown midware "logger"`,
+		}},
+		{"duplicate midware", validBase + `
+midwares:
+- midware: "log"
+- midware: "trace"
+- midware: "log"
+`, []string{
+			`midwares[2].midware: midware "log" is listed more than once`,
+		}},
+		{"cache scope without verified identity", validBase + `
+routes:
+- module: "appstore"
+  path: "/v1/store/list"
+  cache:
+    ttl: "30s"
+    scope: "group"
+`, []string{
+			`routes[0].cache.scope: scope "group" needs the identity verified by Auth`,
+		}},
+		{"canary by user without verified identity", validBase + `
+canaries:
+- module: "appstore"
+  versions:
+  - module: "appstore"
+    weight: 90
+  - module: "appstore-v2"
+    weight: 10
+  users:
+    "1001": "appstore-v2"
+  sticky: "user"
+`, []string{
+			`canaries[0].sticky: sticky "user" needs the identity verified by Auth`,
+			`canaries[0].users: needs the identity verified by Auth`,
+		}},
+		{"approve map mismatch", validBase + `
+approveMap:
+  moduleTransMap:
+    appstore: ` + trans + `
+  operatorTransMap:
+    v1/store/create: ` + trans + `
+  moduleOperateMapGroup:
+    appstore: ["v1/store/create"]
+    datacenter: ["v1/store/create", "v1/dc/delete"]
+`, []string{
+			`approveMap.moduleOperateMapGroup.datacenter: module "datacenter" has no entry in approveMap.moduleTransMap`,
+			`approveMap.moduleOperateMapGroup.datacenter[1]: operate "v1/dc/delete" has no entry in approveMap.operatorTransMap`,
+		}},
+		{"required DB fields and ranges", strings.NewReplacer(`HOST: "127.0.0.1"`, `HOST: ""`, `runmode: "out"`, `runmode: "bogus"`).Replace(validBase), []string{
+			`DB.HOST: is required`,
+			`runmode: unknown value "bogus"`,
+		}},
+		// 类型错误的配置项按零值继续检查，其他错误一起报告
+		{"type errors with value errors", strings.NewReplacer(`port: 30418`, `port: "abc"`, `HOST: "127.0.0.1"`, `HOST: " "`, `runmode: "out"`, `runmode: "bogus"`).Replace(validBase) + `
+midwares:
+- "log"
+- midware: "logger"
+`, []string{
+			`midwares[0]: must be a mapping, got string "log"`,
+			`server.port: must be an integer, got string "abc"`,
+			`DB.HOST: is required`,
+			`runmode: unknown value "bogus"`,
+			`midwares[1].midware: unknown midware "logger"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateYAML(t, tt.yaml)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("errors:\n  %s\nwant %d errors starting with:\n  %s", strings.Join(errs, "\n  "), len(tt.want), strings.Join(tt.want, "\n  "))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(errs[i], want) {
+					t.Errorf("error %d = %q, want prefix %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+// 环境变量中的类型错误不影响其他配置项的检查
+func TestValidateEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(validBase), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IHUB_SERVER_PORT", "abc")
+	t.Setenv("IHUB_RUNMODE", "bogus")
+	t.Setenv("IHUB_DB_HOST", " ")
+	_, err := load(Options{File: file, NoWatch: true})
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("load returned %T %v, want ValidationErrors", err, err)
+	}
+	want := []string{
+		`server.port: must be an integer, got string "abc"`,
+		`DB.HOST: is required`,
+		`runmode: unknown value "bogus"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors:\n  %s\nwant %d errors", strings.Join(errs, "\n  "), len(want))
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(errs[i], w) {
+			t.Errorf("error %d = %q, want prefix %q", i, errs[i], w)
+		}
+	}
+}
@@ -5,6 +5,23 @@ const Destination = "Destination"
 const ClusterName = "ClusterName"
 const ClusterDomain = "ClusterDomain"
 const NeedApprove = "NeedApprove"
+const GRPCMethod = "GRPCMethod"
+const OperateName = "OperateName"
+const UpstreamError = "UpstreamError"
+const UpstreamResponded = "UpstreamResponded"
+const CORSHandled = "CORSHandled"
+const MidwareChain = "MidwareChain"
+const ApprovalDecision = "ApprovalDecision"
+const AdminName = "AdminName"
+const ApproveID = "ApproveID"
+const Approver = "Approver"
+
+// ModuleVersion 灰度路由选择的模块版本，之后的中间件及代理按该版本处理请求
+const ModuleVersion = "ModuleVersion"
+
+// Auth中间件校验令牌后设置的用户及组，请求头中的X-User-ID、X-Group-ID可以由客户端伪造
+const VerifiedUserID = "VerifiedUserID"
+const VerifiedGroupID = "VerifiedGroupID"
 
 // const Role = "Role"
 
@@ -21,6 +38,18 @@ const RoleUser = 2
 const RunmodeOut = "out"
 const RunmodeIn = "in"
 
+// Midware 配置文件midwares中可以使用的中间件名称
+const (
+	MidwareLog       = "log"
+	MidwareTrace     = "trace"
+	MidwareInOut     = "inout"
+	MidwareAuth      = "auth"
+	MidwareApprove   = "approve"
+	MidwareRateLimit = "ratelimit"
+	MidwareCache     = "cache"
+	MidwareCORS      = "cors"
+)
+
 // ClusterStatus
 const ClusterStatusReseting = 2
 const ClusterStatusResetSucceed = 1
@@ -30,9 +59,58 @@ const ClusterStatusResetFailed = 0
 const (
 	HTTPHeaderClusterName = "X-Cluster-Name"
 	HTTPHeaderTraceID     = "X-Trace-ID"
+	// W3C Trace Context请求头
+	HTTPHeaderTraceparent = "traceparent"
+	HTTPHeaderTracestate  = "tracestate"
+	HTTPHeaderUserID      = "X-User-ID"
+	HTTPHeaderGroupID     = "X-Group-ID"
+	// HTTPHeaderMirror 镜像请求带有该请求头，上游可以据此区分影子流量
+	HTTPHeaderMirror = "X-Ihub-Mirror"
+	// HTTPHeaderCanary 响应中返回灰度路由选择的模块版本
+	HTTPHeaderCanary = "X-Ihub-Canary"
+	// 上游模块执行已审批的请求后在响应头中返回审批单ID及审批人，网关记录在审计日志中后从响应中删除。
+	// 客户端请求中的同名请求头不可信，不记录
+	HTTPHeaderApproveID  = "X-Approve-ID"
+	HTTPHeaderApproverID = "X-Approver-ID"
 )
 
 // Default value for rgm
 const (
 	DefaultLogName = "ihub.log"
+	// DefaultAdminAddr 管理接口默认只监听本机
+	DefaultAdminAddr = "127.0.0.1:30419"
+	// DefaultAuditFile 默认的审计日志文件
+	DefaultAuditFile = "audit.log"
+	// DefaultMaxCaptureBytes 日志中记录请求/响应体的默认最大字节数
+	DefaultMaxCaptureBytes = 4096
+	// DefaultCacheEntries 内存中默认最多缓存的响应数
+	DefaultCacheEntries = 1000
+	// DefaultCacheEntryBytes 默认可缓存的最大响应体
+	DefaultCacheEntryBytes = 1 << 20
+	// DefaultCanaryCookie 灰度路由粘性分配默认使用的Cookie
+	DefaultCanaryCookie = "ihub_canary"
+	// 监控指标标签默认最多记录的集群数、模块数、每个模块的接口数及接口保留的路径层数
+	DefaultMetricsMaxClusters   = 50
+	DefaultMetricsMaxModules    = 100
+	DefaultMetricsMaxEndpoints  = 50
+	DefaultMetricsEndpointDepth = 2
+	// 分布式追踪默认的服务名、每次导出的span数及等待导出的span数
+	DefaultTracingServiceName = "ihub"
+	DefaultTracingBatchSize   = 512
+	DefaultTracingQueueSize   = 2048
+)
+
+// Upstream error codes, returned in api.Reply when the proxy fails to reach a module
+const (
+	CodeUpstreamUnknown  = 1000
+	CodeUpstreamDNS      = 1001
+	CodeUpstreamRefused  = 1002
+	CodeUpstreamTimeout  = 1003
+	CodeUpstreamTLS      = 1004
+	CodeUpstreamCanceled = 1005
+	CodeCircuitOpen      = 1006
+	CodeClusterUnhealthy = 1007
+	CodeRequestTooLarge  = 1008
+	CodeRateLimited      = 1009
+	CodeOverloaded       = 1010
 )
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"ihub/pkg/config"
+	"ihub/pkg/logging"
+	"ihub/pkg/metrics"
+	"ihub/pkg/tracing"
 	"ihub/pkg/utils"
 	"time"
 
@@ -75,6 +79,21 @@ var DBInstance db.Session
 // DBErr global DB error
 var DBErr error
 
+// observe 记录查询耗时，请求开启了追踪时记录查询的span，用法为defer observe(ctx, "函数名")()
+func observe(ctx context.Context, function string) func() {
+	start := time.Now()
+	_, span := tracing.StartSpan(ctx, "db "+function, tracing.KindClient)
+	span.SetAttr("db.system", "mysql")
+	span.SetAttr("db.name", config.GetConfig().DB.Name)
+	span.SetAttr("db.operation", function)
+	return func() {
+		elapsed := time.Since(start)
+		metrics.DBQueryDuration.WithLabelValues(function).Observe(elapsed.Seconds())
+		logging.AddDBTime(ctx, elapsed)
+		span.End()
+	}
+}
+
 // 初始化数据库实例
 func Init() error {
 	dbcfg := config.GetConfig().DB
@@ -106,7 +125,8 @@ type NameDomainId struct {
 }
 
 // GetDomainIdByClusterName .
-func GetDomainIdByClusterName(clusterName string) ([]NameDomainId, error) {
+func GetDomainIdByClusterName(ctx context.Context, clusterName string) ([]NameDomainId, error) {
+	defer observe(ctx, "GetDomainIdByClusterName")()
 	var domainId []NameDomainId
 	err := DBInstance.Collection("cluster_manager").
 		Find(db.Cond{"name": clusterName}).
@@ -117,8 +137,23 @@ func GetDomainIdByClusterName(clusterName string) ([]NameDomainId, error) {
 	return domainId, nil
 }
 
+// GetClusters .
+// 查询所有已注册集群的名称、域名和ID
+func GetClusters(ctx context.Context) ([]NameDomainId, error) {
+	defer observe(ctx, "GetClusters")()
+	var clusters []NameDomainId
+	err := DBInstance.Collection("cluster_manager").
+		Find().
+		All(&clusters)
+	if err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
 // GetDomainByClusterId .
-func GetNameDomainByClusterId(clusterId int) ([]NameDomainId, error) {
+func GetNameDomainByClusterId(ctx context.Context, clusterId int) ([]NameDomainId, error) {
+	defer observe(ctx, "GetNameDomainByClusterId")()
 	var nameDomain []NameDomainId
 	err := DBInstance.Collection("cluster_manager").
 		Find(db.Cond{"id": clusterId}).
@@ -135,7 +170,8 @@ type ClusterStatus struct {
 }
 
 // GetClusterStatus .
-func GetClusterStatus(clusterName string) (int, error) {
+func GetClusterStatus(ctx context.Context, clusterName string) (int, error) {
+	defer observe(ctx, "GetClusterStatus")()
 	var clusterStatus ClusterStatus
 	err := DBInstance.Collection("reset_cluster_tracce").
 		Find(db.Cond{"cluster_name": clusterName}).
@@ -154,7 +190,8 @@ type ModuleauthorityModuleid struct {
 
 // GetModuleauthorityModuleid .
 // 根据clusterId、module、role查询module_id
-func GetModuleauthorityModuleid(clusterId int, moduleName string, role int) ([]ModuleauthorityModuleid, error) {
+func GetModuleauthorityModuleid(ctx context.Context, clusterId int, moduleName string, role int) ([]ModuleauthorityModuleid, error) {
+	defer observe(ctx, "GetModuleauthorityModuleid")()
 	// 根据 module_id 连接 approve_rule、 approve_module 两张表查询
 	var moduleauthorityModuleid []ModuleauthorityModuleid
 	req := DBInstance.SQL().
@@ -172,7 +209,8 @@ func GetModuleauthorityModuleid(clusterId int, moduleName string, role int) ([]M
 
 // GetModuleauthorityModuleidInGroup .
 // 根据clusterId、module、组ID查询module_id
-func GetModuleauthorityModuleidInGroup(clusterId int, moduleName string, groupId int) ([]ModuleauthorityModuleid, error) {
+func GetModuleauthorityModuleidInGroup(ctx context.Context, clusterId int, moduleName string, groupId int) ([]ModuleauthorityModuleid, error) {
+	defer observe(ctx, "GetModuleauthorityModuleidInGroup")()
 	// 根据 module_id 连接 approve_rule、 approve_module 两张表查询
 	var moduleauthorityModuleid []ModuleauthorityModuleid
 	req := DBInstance.SQL().
@@ -195,7 +233,8 @@ type Defaultauthority struct {
 
 // GetDefaultauthority .
 // 根据clusterId、module、operate_name查询默认权限
-func GetDefaultauthority(clusterId int, moduleName string, operateName string) ([]Defaultauthority, error) {
+func GetDefaultauthority(ctx context.Context, clusterId int, moduleName string, operateName string) ([]Defaultauthority, error) {
+	defer observe(ctx, "GetDefaultauthority")()
 	// 根据c.source_division b.module_cluster_type 连接 cluster_manager 和 approve_module 两张表查询
 	// 根据 module_id 连接 approve_operate、 approve_module 两张表查询
 	var defaultauthority []Defaultauthority
@@ -221,7 +260,8 @@ type Operatorid struct {
 
 // GetOperatorid .
 // 根据module_id、operate_name查询操操作id
-func GetOperatorid(moduleId int, operateName string) ([]Operatorid, error) {
+func GetOperatorid(ctx context.Context, moduleId int, operateName string) ([]Operatorid, error) {
+	defer observe(ctx, "GetOperatorid")()
 	// 根据 approve_operate 表查询
 	var operatorid []Operatorid
 	req := DBInstance.SQL().
@@ -239,7 +279,8 @@ func GetOperatorid(moduleId int, operateName string) ([]Operatorid, error) {
 // resource_info resource_detail headers createtime userid module_name operate_name status clusterid
 // user_role url method approve_role group_id type
 // 其中 createtime 为当前时间， 使用SQL函数NOW()获取
-func InsertApproveInf(resourceInfo string, resourceDetail string, headers string, userId int, moduleName string, operateName string, status string, clusterId int, userRole string, url string, method string, approveRole string, groupId int, approveType string) error {
+func InsertApproveInf(ctx context.Context, resourceInfo string, resourceDetail string, headers string, userId int, moduleName string, operateName string, status string, clusterId int, userRole string, url string, method string, approveRole string, groupId int, approveType string) error {
+	defer observe(ctx, "InsertApproveInf")()
 	// 根据 approve_inf 表查询
 	req := DBInstance.SQL().
 		InsertInto("approve_inf").
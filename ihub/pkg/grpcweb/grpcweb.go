@@ -0,0 +1,220 @@
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Content-Type
+const (
+	ContentTypeGRPC        = "application/grpc"
+	ContentTypeGRPCWeb     = "application/grpc-web"
+	ContentTypeGRPCWebText = "application/grpc-web-text"
+)
+
+// gRPC状态码
+const (
+	StatusCanceled         = 1
+	StatusUnknown          = 2
+	StatusDeadlineExceeded = 4
+	StatusUnavailable      = 14
+)
+
+// trailerFrameFlag gRPC-Web响应中trailer帧的标志位
+const trailerFrameFlag = 0x80
+
+// IsGRPC 判断是否为原生gRPC请求
+func IsGRPC(req *http.Request) bool {
+	ct := req.Header.Get("Content-Type")
+	return ct == ContentTypeGRPC || strings.HasPrefix(ct, ContentTypeGRPC+"+")
+}
+
+// IsGRPCWeb 判断是否为gRPC-Web请求(包括grpc-web-text)
+func IsGRPCWeb(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), ContentTypeGRPCWeb)
+}
+
+// isText 判断是否为base64编码的grpc-web-text
+func isText(contentType string) bool {
+	return strings.HasPrefix(contentType, ContentTypeGRPCWebText)
+}
+
+// SplitMethod 将gRPC路径/package.Service/Method拆分为服务名和方法名
+func SplitMethod(path string) (string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// TranslateRequest 将gRPC-Web请求转换为gRPC请求，返回原始的Content-Type供响应转换使用
+func TranslateRequest(req *http.Request) string {
+	contentType := req.Header.Get("Content-Type")
+	// application/grpc-web(-text)+proto -> application/grpc+proto
+	suffix := ""
+	if i := strings.Index(contentType, "+"); i >= 0 {
+		suffix = contentType[i:]
+	}
+	req.Header.Set("Content-Type", ContentTypeGRPC+suffix)
+	req.Header.Set("Te", "trailers")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	if isText(contentType) {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{base64.NewDecoder(base64.StdEncoding, req.Body), req.Body}
+	}
+	return contentType
+}
+
+// ResponseWriter 将上游的gRPC响应转换为gRPC-Web响应，trailer以帧的形式写在响应体末尾
+type ResponseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	contentType string
+	wroteHeader bool
+	// text为true时响应体需要base64编码，pending保存不足3字节的待编码数据
+	text    bool
+	pending []byte
+}
+
+// NewResponseWriter .
+func NewResponseWriter(w http.ResponseWriter, contentType string) *ResponseWriter {
+	return &ResponseWriter{
+		w:           w,
+		header:      make(http.Header),
+		contentType: contentType,
+		text:        isText(contentType),
+	}
+}
+
+// Header .
+func (rw *ResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+// WriteHeader 写入响应头，Trailer声明及trailer字段不写入响应头。
+// Trailers-Only响应中的grpc-status由Finish写入trailer帧，同样不写入响应头，客户端只收到一个状态
+func (rw *ResponseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	dst := rw.w.Header()
+	for k, v := range rw.header {
+		if k == "Trailer" || k == "Content-Length" || k == "Grpc-Status" || k == "Grpc-Message" || strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		dst[k] = v
+	}
+	dst.Set("Content-Type", rw.contentType)
+	rw.w.WriteHeader(code)
+}
+
+// Write .
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if err := rw.write(b, false); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush .
+func (rw *ResponseWriter) Flush() {
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// write 写入响应体，text模式下按3字节对齐进行base64编码，final为true时写出剩余数据
+func (rw *ResponseWriter) write(b []byte, final bool) error {
+	if !rw.text {
+		_, err := rw.w.Write(b)
+		return err
+	}
+	data := append(rw.pending, b...)
+	n := len(data)
+	if !final {
+		n -= n % 3
+	}
+	rw.pending = append([]byte(nil), data[n:]...)
+	if n == 0 {
+		return nil
+	}
+	_, err := rw.w.Write([]byte(base64.StdEncoding.EncodeToString(data[:n])))
+	return err
+}
+
+// Finish 在代理结束后调用，将上游的trailer(grpc-status等)编码为trailer帧写入响应体
+func (rw *ResponseWriter) Finish() error {
+	if !rw.wroteHeader {
+		return nil
+	}
+	trailer := rw.trailer()
+	var buf bytes.Buffer
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range trailer[k] {
+			buf.WriteString(strings.ToLower(k) + ": " + v + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+buf.Len())
+	frame[0] = trailerFrameFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(buf.Len()))
+	frame = append(frame, buf.Bytes()...)
+	if err := rw.write(frame, true); err != nil {
+		return err
+	}
+	rw.Flush()
+	return nil
+}
+
+// trailer 收集上游返回的trailer，包括预先声明的、未声明的以及Trailers-Only响应中的grpc-status
+func (rw *ResponseWriter) trailer() http.Header {
+	trailer := make(http.Header)
+	for _, declared := range rw.header.Values("Trailer") {
+		for _, k := range strings.Split(declared, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if v, ok := rw.header[k]; ok {
+				trailer[k] = v
+			}
+		}
+	}
+	for k, v := range rw.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+	// Trailers-Only响应中grpc-status位于响应头中
+	for _, k := range []string{"Grpc-Status", "Grpc-Message"} {
+		if _, ok := trailer[k]; !ok && rw.header.Get(k) != "" {
+			trailer[k] = rw.header[k]
+		}
+	}
+	return trailer
+}
+
+// WriteError 以Trailers-Only的形式返回gRPC错误，适用于gRPC及gRPC-Web客户端
+func WriteError(w http.ResponseWriter, contentType string, status int, message string) {
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Grpc-Status", strconv.Itoa(status))
+	header.Set("Grpc-Message", url.PathEscape(message))
+	w.WriteHeader(http.StatusOK)
+}
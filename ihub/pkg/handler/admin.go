@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ihub/pkg/api"
+	"ihub/pkg/audit"
+	"ihub/pkg/breaker"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/health"
+	"ihub/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Breakers 返回所有熔断器的状态
+func Breakers(c *gin.Context) {
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    breaker.Snapshot(),
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// HealthTable 返回集群网关及模块的健康检查结果
+func HealthTable(c *gin.Context) {
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    health.Snapshot(),
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// ConfigStatus 返回当前配置的版本及最近一次热加载的结果
+func ConfigStatus(c *gin.Context) {
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    config.Status(),
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// EffectiveConfig 返回生效配置中的每一项及其来源，密码等敏感配置项已经隐藏
+func EffectiveConfig(c *gin.Context) {
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    config.GetConfig().Settings(),
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// ConfigRollback 切换回上一个生效的配置
+func ConfigRollback(c *gin.Context) {
+	if err := config.Rollback(); err != nil {
+		rp := api.Reply{
+			Code:    1,
+			Message: err.Error(),
+			Data:    "",
+		}
+		c.JSON(http.StatusOK, rp)
+		return
+	}
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    config.Status(),
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// AuditExport 导出审计日志，每行一条记录，可以按fromSeq、toSeq及since、until(RFC3339)过滤。
+// 导出的内容可以使用ihub -verify-audit校验。
+func AuditExport(c *gin.Context) {
+	f, err := auditFilter(c)
+	if err == nil && audit.Path() == "" {
+		err = errors.New("audit log is not enabled")
+	}
+	if err != nil {
+		rp := api.Reply{
+			Code:    1,
+			Message: err.Error(),
+			Data:    "",
+		}
+		c.JSON(http.StatusOK, rp)
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	// 已经开始输出时无法再返回错误信息，只能中断输出
+	if err := audit.Export(c.Writer, f); err != nil {
+		c.Error(err)
+	}
+}
+
+func auditFilter(c *gin.Context) (audit.Filter, error) {
+	var f audit.Filter
+	var err error
+	if v := c.Query("fromSeq"); v != "" {
+		if f.FromSeq, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return f, errors.New("invalid fromSeq: " + v)
+		}
+	}
+	if v := c.Query("toSeq"); v != "" {
+		if f.ToSeq, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return f, errors.New("invalid toSeq: " + v)
+		}
+	}
+	if v := c.Query("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("invalid since: " + v)
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("invalid until: " + v)
+		}
+	}
+	return f, nil
+}
+
+// AuditVerify 校验正在写入的审计日志，返回校验结果
+func AuditVerify(c *gin.Context) {
+	path := audit.Path()
+	if path == "" {
+		rp := api.Reply{
+			Code:    1,
+			Message: "audit log is not enabled",
+			Data:    "",
+		}
+		c.JSON(http.StatusOK, rp)
+		return
+	}
+	result, err := audit.VerifyFile(path)
+	if err != nil {
+		rp := api.Reply{
+			Code:    1,
+			Message: err.Error(),
+			Data:    "",
+		}
+		c.JSON(http.StatusOK, rp)
+		return
+	}
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    result,
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// logCaptureRequest 开启全量记录的请求体，duration如10m，为空时使用默认的10分钟
+type logCaptureRequest struct {
+	UserID   string `json:"userId"`
+	TraceID  string `json:"traceId"`
+	Duration string `json:"duration"`
+}
+
+// LogCaptures 返回未到期的全量记录
+func LogCaptures(c *gin.Context) {
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    logging.Captures(),
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// AddLogCapture 临时为用户或追踪ID开启全量记录，到期后自动关闭
+func AddLogCapture(c *gin.Context) {
+	var req logCaptureRequest
+	err := c.ShouldBindJSON(&req)
+	var duration time.Duration
+	if err == nil && req.Duration != "" {
+		duration, err = time.ParseDuration(req.Duration)
+	}
+	var cp logging.Capture
+	if err == nil {
+		cp, err = logging.AddCapture(req.UserID, req.TraceID, duration, adminName(c))
+	}
+	if err != nil {
+		rp := api.Reply{
+			Code:    1,
+			Message: err.Error(),
+			Data:    "",
+		}
+		c.JSON(http.StatusOK, rp)
+		return
+	}
+	rp := api.DataReply{
+		Code:    0,
+		Message: "ok",
+		Data:    cp,
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// RemoveLogCapture 提前关闭全量记录
+func RemoveLogCapture(c *gin.Context) {
+	if !logging.RemoveCapture(c.Param("id"), adminName(c)) {
+		rp := api.Reply{
+			Code:    1,
+			Message: "capture " + c.Param("id") + " not found",
+			Data:    "",
+		}
+		c.JSON(http.StatusOK, rp)
+		return
+	}
+	rp := api.Reply{
+		Code:    0,
+		Message: "ok",
+		Data:    "",
+	}
+	c.JSON(http.StatusOK, rp)
+}
+
+// adminName 返回操作的管理员，没有配置管理员令牌时为客户端地址
+func adminName(c *gin.Context) string {
+	if name := c.GetString(constants.AdminName); name != "" {
+		return name
+	}
+	return c.ClientIP()
+}
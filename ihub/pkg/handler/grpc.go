@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"ihub/pkg/grpcweb"
+
+	"golang.org/x/net/http2"
+)
+
+// h2cTransport 以明文HTTP/2(h2c)连接集群内的gRPC服务
+var h2cTransport = &http2.Transport{
+	AllowHTTP: true,
+	DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+		return net.Dial(network, addr)
+	},
+}
+
+// grpcContentType 返回gRPC及gRPC-Web请求的Content-Type，其他请求返回空字符串
+func grpcContentType(req *http.Request) string {
+	if grpcweb.IsGRPC(req) || grpcweb.IsGRPCWeb(req) {
+		return req.Header.Get("Content-Type")
+	}
+	return ""
+}
+
+// grpcStatus 将上游错误类型转换为gRPC状态码
+func grpcStatus(kind string) int {
+	switch kind {
+	case upstreamErrCanceled:
+		return grpcweb.StatusCanceled
+	case upstreamErrTimeout:
+		return grpcweb.StatusDeadlineExceeded
+	case upstreamErrUnknown:
+		return grpcweb.StatusUnknown
+	default:
+		return grpcweb.StatusUnavailable
+	}
+}
@@ -1,12 +1,23 @@
 package handler
 
 import (
+	"errors"
 	"ihub/pkg/api"
+	"ihub/pkg/balancer"
+	"ihub/pkg/breaker"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
 	mydb "ihub/pkg/db"
+	"ihub/pkg/grpcweb"
+	"ihub/pkg/limiter"
+	"ihub/pkg/logging"
+	"ihub/pkg/metrics"
+	"ihub/pkg/tracing"
 	"ihub/pkg/utils"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,24 +33,71 @@ func Health(c *gin.Context) {
 }
 
 func Proxy(c *gin.Context) {
-	// 从请求头中获取X-Cluster-Name，该请求头中包含了当前请求需要访问的集群名称。
-	// 如果请求头中不包含X-Cluster-Name，则返回一个错误信息。
-	v, ok := c.Request.Header["X-Cluster-Name"]
-	if !ok {
-		rp := api.Reply{
-			Code:    1,
-			Message: "缺少集群名称",
-			Data:    "",
+	// Canary中间件按灰度路由配置选择了模块版本，版本没有单独的路由配置时使用原模块的路由配置
+	module := c.Param("moudle")
+	if version := c.GetString(constants.ModuleVersion); version != "" {
+		module = version
+	}
+	route := config.GetConfig().MatchRoute(module, c.Param("proxyPath"))
+	if route == nil && module != c.Param("moudle") {
+		route = config.GetConfig().MatchRoute(c.Param("moudle"), c.Param("proxyPath"))
+	}
+
+	var clusterName, domain, targetURL, realPath string
+	if name, ok := c.Get(constants.ClusterName); ok {
+		// InOut中间件已经解析集群名称并按负载均衡策略选择了域名
+		clusterName = name.(string)
+		domain = c.GetString(constants.ClusterDomain)
+	} else {
+		// 从请求头中获取X-Cluster-Name，该请求头中包含了当前请求需要访问的集群名称。
+		// 如果请求头中不包含X-Cluster-Name，则返回一个错误信息。
+		v, ok := c.Request.Header["X-Cluster-Name"]
+		if !ok {
+			rp := api.Reply{
+				Code:    1,
+				Message: "缺少集群名称",
+				Data:    "",
+			}
+			c.JSON(http.StatusOK, rp)
+			return
 		}
-		c.JSON(http.StatusOK, rp)
-		return
+		clusterName = v[0]
+
+		// 根据集群名称获取对应的域名，同一集群有多个域名时按负载均衡策略选择
+		nameDomainIdList, err := mydb.GetDomainIdByClusterName(c.Request.Context(), clusterName)
+		if err != nil {
+			rp := api.Reply{
+				Code:    1,
+				Message: err.Error(),
+				Data:    "",
+			}
+			c.JSON(http.StatusOK, rp)
+			return
+		}
+		endpoint, err := balancer.Pick(nameDomainIdList, module, balancer.HashValue(c.Request.Header, clusterName))
+		if err != nil {
+			rp := api.Reply{
+				Code:    1,
+				Message: "集群不存在",
+				Data:    "",
+			}
+			c.JSON(http.StatusOK, rp)
+			return
+		}
+		domain = endpoint.Domain
+	}
+	if domain != "" {
+		// 获取目标URL和真实路径
+		// c.Param("proxyPath")获取请求路径中模块名称之后的部分，如localhost:8080/<moudle>/<proxyPath>
+		// 完整路径(fullPath) = /模块名称(moudle)/真实路径(realPath)
+		// 目标URL(targetURL)，格式为http://模块名称.default.域名
+		targetURL, realPath = utils.MakeURL(domain, "/"+module+c.Param("proxyPath"))
+		// 记录发往该域名的请求数，用于最少连接策略
+		defer balancer.Acquire(domain)()
 	}
 
-	// 根据集群名称获取对应的域名
-	// domain, err := mydb.GetDomainByClusterName(v[0])
-	// domain, _, err := mydb.GetDomainIdByClusterName(v[0])
-	nameDomainIdList, err := mydb.GetDomainIdByClusterName(v[0])
-	domain := nameDomainIdList[0].Domain
+	// Parse方法将字符串解析为URL结构体，并返回一个指向URL结构体的指针和一个错误值。
+	remote, err := url.Parse(targetURL)
 	if err != nil {
 		rp := api.Reply{
 			Code:    1,
@@ -50,25 +108,76 @@ func Proxy(c *gin.Context) {
 		return
 	}
 
-	// 获取目标URL和真实路径
-	// c.Param("proxyPath")获取请求路径中的proxyPath参数，如localhost:8080/api/v1/<proxyPath>
-	// proxyPath = /完整路径(fullPath) = /模块名称(moudle)/真实路径(realPath)
-	// 目标URL(targetURL)，格式为http://模块名称.default.域名
+	// 请求体大小限制，Content-Length超过限制时直接返回，分块传输时在读取过程中检查
+	if limit := maxBodyBytes(c.Request, route); limit > 0 {
+		if c.Request.ContentLength > limit {
+			rp := api.Reply{
+				Code:    constants.CodeRequestTooLarge,
+				Message: "请求体过大",
+				Data:    "",
+				Cluster: clusterName,
+				Module:  module,
+			}
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, rp)
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+	}
 
-	targetURL, realPath := utils.MakeURL(domain, c.Param("proxyPath"))
+	// 发往同一(集群、模块)的请求数超过上限时排队，队列已满或排队超时返回503
+	if cfg, ok := concurrencyConfig(c.Request, route); ok {
+		release, err := limiter.Get(clusterName, module).Acquire(c.Request.Context(), cfg, limiter.Priority(c.Request.Method))
+		if err != nil {
+			metrics.ConcurrencyRejections.WithLabelValues(metrics.Cluster(clusterName), metrics.Module(module), concurrencyReason(err)).Inc()
+			c.Header("Retry-After", "1")
+			rp := api.Reply{
+				Code:    constants.CodeOverloaded,
+				Message: "模块繁忙，请稍后重试",
+				Data:    "",
+				Cluster: clusterName,
+				Module:  module,
+			}
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, rp)
+			return
+		}
+		defer release()
+	}
 
-	// Parse方法将字符串解析为URL结构体，并返回一个指向URL结构体的指针和一个错误值。
-	remote, err := url.Parse(targetURL)
-	if err != nil {
-		rp := api.Reply{
-			Code:    1,
-			Message: err.Error(),
-			Data:    "",
+	// 熔断器打开时快速失败，不再等待上游超时
+	if cfg := config.GetConfig().Proxy.Breaker; cfg.Enabled {
+		done, err := breaker.Get(clusterName, module).Allow(cfg)
+		if err != nil {
+			rp := api.Reply{
+				Code:    constants.CodeCircuitOpen,
+				Message: "模块暂时不可用，请稍后重试",
+				Data:    "",
+				Cluster: clusterName,
+				Module:  module,
+			}
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, rp)
+			return
 		}
-		c.JSON(http.StatusOK, rp)
-		return
+		defer func() { done(!upstreamFailed(c)) }()
 	}
 
+	// 按比例将请求复制到镜像集群，原请求结束后比较状态码及耗时
+	mirrorDone := startMirror(c, route, module)
+	defer func(start time.Time) { mirrorDone(c.Writer.Status(), time.Since(start)) }(time.Now())
+
+	// 转发到上游的span，追踪上下文通过traceparent传递给上游，上游不可达时在ErrorHandler中记录错误
+	ctx, span := tracing.StartSpan(c.Request.Context(), "proxy "+module, tracing.KindClient)
+	c.Request = c.Request.WithContext(ctx)
+	span.SetAttr("ihub.cluster", clusterName)
+	span.SetAttr("ihub.module", module)
+	span.SetAttr("net.peer.name", remote.Host)
+	defer func(start time.Time) {
+		span.SetAttr("http.status_code", c.Writer.Status())
+		span.End()
+		logging.AddUpstreamTime(ctx, time.Since(start))
+	}(time.Now())
+
 	// 创建一个httputil.ReverseProxy类型的代理对象，并设置其属性，将请求转发到目标URL
 	// NewSingleHostReverseProxy的参数是一个指向URL结构体的指针，用于指定目标URL。
 	proxy := httputil.NewSingleHostReverseProxy(remote)
@@ -76,19 +185,106 @@ func Proxy(c *gin.Context) {
 	// 该函数的第一个参数是一个指向http.Request类型的指针，用于获取请求的属性。
 	// 函数体中将请求头、请求路径等属性修改为目标URL的属性。
 	proxy.Director = func(req *http.Request) {
-		// Header属性是一个map，用于存储请求头(Headers)
-		req.Header = c.Request.Header
+		// req是ReverseProxy复制的请求，Header已经是c.Request.Header的副本，
+		// 不能直接使用c.Request.Header，否则删除逐跳请求头等修改会影响原请求
 		// Host属性是请求头中的Host字段，用于指定请求的主机名(模块名称.default.域名)，会解析为IP地址。
 		req.Host = remote.Host
 		// Scheme属性是请求头中的Scheme字段，用于指定请求的协议(http、https)
 		req.URL.Scheme = remote.Scheme
 		// URL.Host属性是请求头中的Host字段，用于指定请求的主机名(模块名称.default.域名)
 		req.URL.Host = remote.Host
-		// URL.Path属性是请求头中的Path字段，用于指定请求的路径。
-		req.URL.Path = realPath
+		// URL.Path属性是请求头中的Path字段，用于指定请求的路径，路径中有编码的字符时保留原始编码，查询参数不变。
+		setPath(req.URL, c.Request.URL.EscapedPath(), realPath)
+		setForwarded(req, c.Request)
+		// 按路由配置改写路径、查询参数及请求头
+		rewriteRequest(req, route)
+		// 传递追踪上下文
+		tracing.Inject(req.Context(), req.Header)
+	}
+	// gRPC及gRPC-Web请求通过h2c转发到集群内的gRPC服务
+	contentType := grpcContentType(c.Request)
+	if contentType != "" {
+		proxy.Transport = h2cTransport
+	} else if !utils.IsStreamRequest(c.Request) && (route == nil || !route.Stream) {
+		// 普通请求按配置在连接失败等情况下重试，长连接不重试
+		proxy.Transport = newRetryTransport(http.DefaultTransport, route, clusterName, module)
+	}
+	// 标记响应来自上游(响应缓存只缓存上游的响应)，过滤上游响应头并添加安全响应头，最后按路由配置改写响应头
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		c.Set(constants.UpstreamResponded, true)
+		takeApproval(c, resp.Header)
+		sanitizeResponse(resp, route, c.GetBool(constants.CORSHandled))
+		rewriteResponse(resp, route)
+		return nil
+	}
+	// ErrorHandler属性用于处理上游不可达的情况，返回带错误码的api.Reply而不是空的502
+	proxy.ErrorHandler = proxyErrorHandler(c, clusterName, module, contentType)
+	// 长连接(SSE、日志跟踪)每次写入后立即刷新，WebSocket升级由ReverseProxy直接接管连接
+	if utils.IsStreamRequest(c.Request) || (route != nil && route.Stream) {
+		proxy.FlushInterval = -1
 	}
+	serveProxy(c, proxy, contentType)
+}
+
+// serveProxy 将请求转发到上游，gRPC-Web请求转换为gRPC请求，响应转换回gRPC-Web并将trailer写入响应体
+func serveProxy(c *gin.Context, proxy *httputil.ReverseProxy, contentType string) {
 	// ServeHTTP方法用于将请求转发到目标URL
 	// 第一个参数是一个ResponseWriter类型的对象，用于将响应返回给客户端。
 	// 第二个参数是一个指向http.Request类型的指针，用于获取请求的属性，传递给Director函数。
+	if grpcweb.IsGRPCWeb(c.Request) {
+		grpcweb.TranslateRequest(c.Request)
+		rw := grpcweb.NewResponseWriter(c.Writer, contentType)
+		proxy.ServeHTTP(rw, c.Request)
+		rw.Finish()
+		return
+	}
 	proxy.ServeHTTP(c.Writer, c.Request)
 }
+
+// takeApproval 保存上游返回的审批单ID及审批人，审计日志中只记录上游确认的审批信息
+func takeApproval(c *gin.Context, header http.Header) {
+	if id := header.Get(constants.HTTPHeaderApproveID); id != "" {
+		c.Set(constants.ApproveID, id)
+	}
+	if approver := header.Get(constants.HTTPHeaderApproverID); approver != "" {
+		c.Set(constants.Approver, approver)
+	}
+	header.Del(constants.HTTPHeaderApproveID)
+	header.Del(constants.HTTPHeaderApproverID)
+}
+
+// maxBodyBytes 返回请求体大小限制，路由配置优先，长连接请求只使用路由中的配置
+func maxBodyBytes(req *http.Request, route *config.RouteConfig) int64 {
+	if route != nil && route.MaxBodyBytes > 0 {
+		return route.MaxBodyBytes
+	}
+	if utils.IsStreamRequest(req) || (route != nil && route.Stream) {
+		return 0
+	}
+	return config.GetConfig().SERVER.MaxBodyBytes
+}
+
+// concurrencyConfig 返回并发限制配置，路由配置优先，长连接不计入并发数
+func concurrencyConfig(req *http.Request, route *config.RouteConfig) (config.ConcurrencyConfig, bool) {
+	if utils.IsStreamRequest(req) || (route != nil && route.Stream) {
+		return config.ConcurrencyConfig{}, false
+	}
+	cfg := config.GetConfig().Proxy.Concurrency
+	if route != nil && route.Concurrency != nil {
+		cfg = *route.Concurrency
+	}
+	return cfg, cfg.MaxInflight > 0
+}
+
+// concurrencyReason 返回并发限制拒绝请求的原因，用于监控指标
+func concurrencyReason(err error) string {
+	switch {
+	case errors.Is(err, limiter.ErrQueueFull):
+		return "queue_full"
+	case errors.Is(err, limiter.ErrQueueTimeout):
+		return "queue_timeout"
+	case errors.Is(err, limiter.ErrEvicted):
+		return "evicted"
+	}
+	return "canceled"
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ihub/pkg/balancer"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	mydb "ihub/pkg/db"
+	"ihub/pkg/metrics"
+	"ihub/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// mirrorInflight 同时进行的镜像请求上限，超过时丢弃镜像请求
+const mirrorInflight = 64
+
+// defaultMirrorTimeout 未配置超时时镜像请求的超时时间
+const defaultMirrorTimeout = 10 * time.Second
+
+var mirrorSem = make(chan struct{}, mirrorInflight)
+
+// mirrorClient 镜像请求不跟随重定向，响应直接丢弃
+var mirrorClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// mirrorResult 请求的状态码及耗时
+type mirrorResult struct {
+	status  int
+	latency time.Duration
+}
+
+// startMirror 按比例将请求复制到镜像集群，镜像请求在单独的goroutine中发送，不影响原请求的耗时。
+// 返回的函数需要在原请求结束后调用，传入原请求的状态码及耗时用于比较。
+func startMirror(c *gin.Context, route *config.RouteConfig, module string) func(status int, latency time.Duration) {
+	noop := func(int, time.Duration) {}
+	if route == nil || route.Mirror == nil || route.Stream || utils.IsStreamRequest(c.Request) {
+		return noop
+	}
+	cfg := *route.Mirror
+	if !mirrorMethod(cfg, c.Request.Method) || rand.Float64()*100 >= cfg.Percent {
+		return noop
+	}
+	body, ok := mirrorBody(c.Request, cfg.MaxBodyBytes)
+	if !ok {
+		return noop
+	}
+	select {
+	case mirrorSem <- struct{}{}:
+	default:
+		metrics.MirrorRequests.WithLabelValues(module, "dropped").Inc()
+		return noop
+	}
+
+	method, proxyPath, query := c.Request.Method, c.Param("proxyPath"), c.Request.URL.RawQuery
+	header := c.Request.Header.Clone()
+	primary := make(chan mirrorResult, 1)
+	go func() {
+		defer func() { <-mirrorSem }()
+		mirror, err := sendMirror(cfg, module, method, proxyPath, query, header, body)
+		fields := logrus.Fields{"module": module, "method": method, "path": proxyPath}
+		if err != nil {
+			metrics.MirrorRequests.WithLabelValues(module, "error").Inc()
+			fields["error"] = err
+			logrus.WithFields(fields).Debug("mirror request failed")
+			return
+		}
+		if !cfg.Compare {
+			metrics.MirrorRequests.WithLabelValues(module, "ok").Inc()
+			return
+		}
+		p := <-primary
+		fields["primary_status"] = p.status
+		fields["mirror_status"] = mirror.status
+		fields["primary_latency"] = p.latency
+		fields["mirror_latency"] = mirror.latency
+		fields["latency_diff"] = mirror.latency - p.latency
+		if p.status != mirror.status {
+			metrics.MirrorRequests.WithLabelValues(module, "mismatch").Inc()
+			logrus.WithFields(fields).Warn("mirror status mismatch")
+			return
+		}
+		metrics.MirrorRequests.WithLabelValues(module, "match").Inc()
+		logrus.WithFields(fields).Debug("mirror status match")
+	}()
+	return func(status int, latency time.Duration) {
+		primary <- mirrorResult{status: status, latency: latency}
+	}
+}
+
+// sendMirror 发送镜像请求并丢弃响应体
+func sendMirror(cfg config.MirrorConfig, module string, method string, proxyPath string, query string, header http.Header, body []byte) (mirrorResult, error) {
+	targetURL, realPath := cfg.Target, proxyPath
+	if targetURL == "" {
+		// 镜像请求在原请求结束后继续执行，不记录在原请求的追踪中
+		list, err := mydb.GetDomainIdByClusterName(context.Background(), cfg.Cluster)
+		if err != nil {
+			return mirrorResult{}, err
+		}
+		endpoint, err := balancer.Pick(list, module, balancer.HashValue(header, cfg.Cluster))
+		if err != nil {
+			return mirrorResult{}, err
+		}
+		targetURL, realPath = utils.MakeURL(endpoint.Domain, "/"+module+proxyPath)
+		header.Set(constants.HTTPHeaderClusterName, cfg.Cluster)
+	}
+	remote, err := url.Parse(targetURL)
+	if err != nil {
+		return mirrorResult{}, err
+	}
+	remote.Path, remote.RawQuery = realPath, query
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, remote.String(), bytes.NewReader(body))
+	if err != nil {
+		return mirrorResult{}, err
+	}
+	req.Header = header
+	req.Header.Set(constants.HTTPHeaderMirror, "true")
+
+	start := time.Now()
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return mirrorResult{}, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return mirrorResult{status: resp.StatusCode, latency: time.Since(start)}, nil
+}
+
+// mirrorMethod 判断请求方法是否需要镜像，默认只镜像GET、HEAD
+func mirrorMethod(cfg config.MirrorConfig, method string) bool {
+	if len(cfg.Methods) == 0 {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	for _, m := range cfg.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorBody 读取请求体供镜像请求使用，原请求体替换为已读取的内容。
+// 请求体长度未知或超过limit时不镜像，limit为0时只镜像没有请求体的请求。
+func mirrorBody(req *http.Request, limit int64) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 {
+		return nil, true
+	}
+	if req.ContentLength < 0 || req.ContentLength > limit {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+	return body, err == nil
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"ihub/pkg/api"
+	"ihub/pkg/constants"
+	"ihub/pkg/grpcweb"
+	"ihub/pkg/metrics"
+	"ihub/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 上游错误类型
+const (
+	upstreamErrDNS      = "dns"
+	upstreamErrRefused  = "refused"
+	upstreamErrTimeout  = "timeout"
+	upstreamErrTLS      = "tls"
+	upstreamErrCanceled = "canceled"
+	upstreamErrTooLarge = "too_large"
+	upstreamErrUnknown  = "unknown"
+)
+
+// statusClientClosedRequest 客户端主动断开连接时记录的状态码(与nginx保持一致)
+const statusClientClosedRequest = 499
+
+// upstreamError 上游错误的分类结果
+type upstreamError struct {
+	Kind    string
+	Code    int
+	Status  int
+	Message string
+}
+
+// classifyUpstreamError 根据ReverseProxy返回的错误判断上游失败的原因
+func classifyUpstreamError(req *http.Request, err error) upstreamError {
+	// 客户端取消请求时，上游的错误没有意义
+	if errors.Is(err, context.Canceled) || errors.Is(req.Context().Err(), context.Canceled) {
+		return upstreamError{upstreamErrCanceled, constants.CodeUpstreamCanceled, statusClientClosedRequest, "客户端已取消请求"}
+	}
+
+	// 请求体超过限制，由http.MaxBytesReader返回
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return upstreamError{upstreamErrTooLarge, constants.CodeRequestTooLarge, http.StatusRequestEntityTooLarge, "请求体过大"}
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && !dnsErr.IsTimeout {
+		return upstreamError{upstreamErrDNS, constants.CodeUpstreamDNS, http.StatusBadGateway, "模块域名解析失败"}
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return upstreamError{upstreamErrRefused, constants.CodeUpstreamRefused, http.StatusBadGateway, "模块拒绝连接"}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return upstreamError{upstreamErrTimeout, constants.CodeUpstreamTimeout, http.StatusGatewayTimeout, "模块响应超时"}
+	}
+
+	if isTLSError(err) {
+		return upstreamError{upstreamErrTLS, constants.CodeUpstreamTLS, http.StatusBadGateway, "与模块建立TLS连接失败"}
+	}
+
+	return upstreamError{upstreamErrUnknown, constants.CodeUpstreamUnknown, http.StatusBadGateway, "模块不可用"}
+}
+
+// isTLSError 判断是否为证书校验或TLS握手错误
+func isTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostnameErr      x509.HostnameError
+		recordHeaderErr  tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &recordHeaderErr) {
+		return true
+	}
+	// 握手失败等错误没有导出类型，只能根据错误信息判断
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+// proxyErrorHandler 返回ReverseProxy的ErrorHandler，将上游错误转换为api.Reply，
+// gRPC及gRPC-Web请求(contentType不为空)则返回对应的gRPC状态码
+func proxyErrorHandler(c *gin.Context, clusterName string, module string, contentType string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, req *http.Request, err error) {
+		ue := classifyUpstreamError(req, err)
+		c.Set(constants.UpstreamError, ue.Kind)
+		tracing.SpanFromContext(req.Context()).SetError(ue.Kind + ": " + err.Error())
+		metrics.ProxyUpstreamErrors.WithLabelValues(ue.Kind, metrics.Cluster(clusterName), metrics.Module(module)).Inc()
+		logrus.WithFields(logrus.Fields{
+			"kind":     ue.Kind,
+			"cluster":  clusterName,
+			"module":   module,
+			"trace_id": req.Header.Get(constants.HTTPHeaderTraceID),
+			"url":      req.URL.String(),
+		}).Warn(err)
+
+		if contentType != "" {
+			// gRPC-Web请求的w为grpcweb.ResponseWriter，错误需要经过它写入，由Finish写出唯一的trailer帧
+			grpcweb.WriteError(w, contentType, grpcStatus(ue.Kind), ue.Message)
+			c.Abort()
+			return
+		}
+		rp := api.Reply{
+			Code:    ue.Code,
+			Message: ue.Message,
+			Data:    "",
+			Cluster: clusterName,
+			Module:  module,
+		}
+		c.AbortWithStatusJSON(ue.Status, rp)
+	}
+}
+
+// upstreamFailed 判断代理结束后上游是否失败(连接失败或网关类错误)，客户端取消请求及请求体过大不算失败
+func upstreamFailed(c *gin.Context) bool {
+	if kind, ok := c.Get(constants.UpstreamError); ok {
+		return kind != upstreamErrCanceled && kind != upstreamErrTooLarge
+	}
+	switch c.Writer.Status() {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ihub/pkg/constants"
+	"ihub/pkg/grpcweb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refusedURL 返回一个已关闭端口的地址，连接时被拒绝
+func refusedURL(t *testing.T) *url.URL {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return &url.URL{Scheme: "http", Host: addr}
+}
+
+func TestGRPCWebUpstreamRefused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name        string
+		contentType string
+		decode      func([]byte) ([]byte, error)
+	}{
+		{"binary", grpcweb.ContentTypeGRPCWeb + "+proto", func(b []byte) ([]byte, error) { return b, nil }},
+		{"text", grpcweb.ContentTypeGRPCWebText + "+proto", func(b []byte) ([]byte, error) {
+			return base64.StdEncoding.DecodeString(string(b))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/greeter/pkg.Greeter/Hello", bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+			c.Request.Header.Set("Content-Type", tt.contentType)
+
+			contentType := grpcContentType(c.Request)
+			proxy := httputil.NewSingleHostReverseProxy(refusedURL(t))
+			proxy.Transport = h2cTransport
+			proxy.ErrorHandler = proxyErrorHandler(c, "c1", "greeter", contentType)
+			serveProxy(c, proxy, contentType)
+
+			if got := c.GetString(constants.UpstreamError); got != upstreamErrRefused {
+				t.Fatalf("upstream error = %q, want %q", got, upstreamErrRefused)
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := rec.Header().Get("Grpc-Status"); got != "" {
+				t.Errorf("grpc-status in headers = %q, want it only in the trailer frame", got)
+			}
+
+			body, err := tt.decode(rec.Body.Bytes())
+			if err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if len(body) < 5 || body[0] != 0x80 {
+				t.Fatalf("body = %q, want a single trailer frame", body)
+			}
+			n := binary.BigEndian.Uint32(body[1:5])
+			if int(n) != len(body)-5 {
+				t.Fatalf("trailer frame length = %d, body has %d bytes after the frame header", n, len(body)-5)
+			}
+			trailer := string(body[5:])
+			if strings.Count(trailer, "grpc-status:") != 1 || !strings.Contains(trailer, "grpc-status: 14\r\n") {
+				t.Errorf("trailer = %q, want one grpc-status: 14", trailer)
+			}
+		})
+	}
+}
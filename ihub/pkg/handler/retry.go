@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HTTPHeaderIdempotencyKey 携带该请求头的非幂等请求(PUT、POST等)允许重试
+const HTTPHeaderIdempotencyKey = "Idempotency-Key"
+
+// retryBudgetWindow 重试预算的统计周期
+const retryBudgetWindow = 10 * time.Second
+
+// retryTransport 在上游连接失败或返回指定状态码时按配置重试
+type retryTransport struct {
+	base    http.RoundTripper
+	policy  config.RetryConfig
+	cluster string
+	module  string
+}
+
+// newRetryTransport 根据路由及全局配置创建重试Transport，不需要重试时直接返回base
+func newRetryTransport(base http.RoundTripper, route *config.RouteConfig, cluster string, module string) http.RoundTripper {
+	policy := config.GetConfig().Proxy.Retry
+	if route != nil && route.Retry != nil {
+		policy = *route.Retry
+	}
+	if policy.Attempts <= 1 {
+		return base
+	}
+	return &retryTransport{base: base, policy: policy, cluster: cluster, module: module}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	budget := retryBudgets.get(t.cluster)
+	budget.request()
+
+	body, replayable := t.bufferBody(req)
+	idempotent := isIdempotent(req)
+	for attempt := 1; ; attempt++ {
+		// 每次尝试使用新的请求对象及请求体
+		attemptReq := req
+		if body != nil {
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.policy.Attempts || !t.shouldRetry(req, resp, err, replayable, idempotent) {
+			return resp, err
+		}
+		if !budget.withdraw(t.policy) {
+			metrics.ProxyRetries.WithLabelValues(metrics.Cluster(t.cluster), metrics.Module(t.module), "budget_exhausted").Inc()
+			return resp, err
+		}
+		if resp != nil {
+			// 丢弃本次响应，释放连接
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		metrics.ProxyRetries.WithLabelValues(metrics.Cluster(t.cluster), metrics.Module(t.module), "retried").Inc()
+		logrus.WithFields(logrus.Fields{
+			"cluster": t.cluster,
+			"module":  t.module,
+			"attempt": attempt,
+			"url":     req.URL.String(),
+		}).Info("retry upstream request")
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(t.backoff(attempt)):
+		}
+	}
+}
+
+// bufferBody 缓存请求体以便重试，请求体超过MaxBodyBytes时返回false，请求仍然可以正常转发
+func (t *retryTransport) bufferBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, t.policy.MaxBodyBytes+1))
+	if err != nil || int64(len(buf)) > t.policy.MaxBodyBytes {
+		// 已读取的部分与剩余部分拼接后继续转发
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return nil, false
+	}
+	return buf, true
+}
+
+// shouldRetry 判断是否需要重试，请求体未能完整缓存(replayable为false)时不重试
+// 连接被拒绝时请求尚未发送到上游，任何请求都可以重试；其它错误及指定状态码只重试幂等请求
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error, replayable bool, idempotent bool) bool {
+	if !replayable || req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return true
+		}
+		return idempotent && (errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF))
+	}
+	if !idempotent {
+		return false
+	}
+	for _, status := range t.policy.Statuses {
+		if resp.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 指数退避，加入随机抖动避免重试同时到达上游
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.policy.Backoff << uint(attempt-1)
+	if t.policy.MaxBackoff > 0 && (d > t.policy.MaxBackoff || d <= 0) {
+		d = t.policy.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isIdempotent 判断请求是否幂等
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.Header.Get(HTTPHeaderIdempotencyKey) != ""
+}
+
+// retryBudget 统计周期内的请求数和重试数，重试数不超过请求数*BudgetRatio+MinRetries
+type retryBudget struct {
+	mu       sync.Mutex
+	start    time.Time
+	requests int
+	retries  int
+}
+
+func (b *retryBudget) reset(now time.Time) {
+	if now.Sub(b.start) > retryBudgetWindow {
+		b.start = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reset(time.Now())
+	b.requests++
+}
+
+// withdraw 预算充足时记录一次重试并返回true
+func (b *retryBudget) withdraw(policy config.RetryConfig) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reset(time.Now())
+	if float64(b.retries) >= float64(b.requests)*policy.BudgetRatio+float64(policy.MinRetries) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// retryBudgetMap 按集群保存重试预算。集群名称已经在cluster_manager中查到了域名，
+// 不使用来自请求路径的模块名称作为key，预算数不会超过集群数
+type retryBudgetMap struct {
+	mu      sync.Mutex
+	budgets map[string]*retryBudget
+}
+
+var retryBudgets = &retryBudgetMap{budgets: map[string]*retryBudget{}}
+
+func (m *retryBudgetMap) get(cluster string) *retryBudget {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.budgets[cluster]
+	if !ok {
+		b = &retryBudget{start: time.Now()}
+		m.budgets[cluster] = b
+	}
+	return b
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ihub/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	patternMu sync.Mutex
+	// patterns 已编译的路径改写正则表达式，配置热加载后按新的表达式重新编译
+	patterns = map[string]*regexp.Regexp{}
+)
+
+// setPath 设置转发路径，路径中有编码的字符(如%2F)时保留原始编码
+func setPath(u *url.URL, rawPath string, realPath string) {
+	u.Path, u.RawPath = realPath, ""
+	for i := 0; i < len(rawPath); i++ {
+		if rawPath[i] != '/' {
+			continue
+		}
+		if p, err := url.PathUnescape(rawPath[i:]); err == nil && p == realPath {
+			if rawPath[i:] != u.EscapedPath() {
+				u.RawPath = rawPath[i:]
+			}
+			return
+		}
+	}
+}
+
+// rewriteRequest 按路由的改写规则修改转发到上游的请求，并删除配置的内部请求头
+func rewriteRequest(req *http.Request, route *config.RouteConfig) {
+	for _, name := range config.GetConfig().Proxy.StripHeaders {
+		req.Header.Del(name)
+	}
+	if route == nil || route.Rewrite == nil {
+		return
+	}
+	rw := route.Rewrite
+	if rw.Path != nil && rw.Path.Pattern != "" {
+		if re := compilePattern(rw.Path.Pattern); re != nil {
+			if p := re.ReplaceAllString(req.URL.Path, rw.Path.Replacement); p != req.URL.Path {
+				req.URL.Path, req.URL.RawPath = p, ""
+			}
+		}
+	}
+	if len(rw.Query) > 0 {
+		q := req.URL.Query()
+		for _, kv := range rw.Query {
+			q.Set(kv.Name, kv.Value)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	rewriteHeader(req.Header, rw.RequestHeaders)
+}
+
+// rewriteResponse 按路由的改写规则修改返回给客户端的响应头
+func rewriteResponse(resp *http.Response, route *config.RouteConfig) {
+	if route == nil || route.Rewrite == nil {
+		return
+	}
+	rewriteHeader(resp.Header, route.Rewrite.ResponseHeaders)
+}
+
+func rewriteHeader(h http.Header, rules config.HeaderRewriteConfig) {
+	for _, name := range rules.Remove {
+		h.Del(name)
+	}
+	for _, r := range rules.Rename {
+		if v, ok := h[http.CanonicalHeaderKey(r.From)]; ok {
+			h.Del(r.From)
+			h[http.CanonicalHeaderKey(r.To)] = v
+		}
+	}
+	for _, kv := range rules.Set {
+		h.Set(kv.Name, kv.Value)
+	}
+	for _, kv := range rules.Add {
+		h.Add(kv.Name, kv.Value)
+	}
+}
+
+func compilePattern(pattern string) *regexp.Regexp {
+	patternMu.Lock()
+	defer patternMu.Unlock()
+	if re, ok := patterns[pattern]; ok {
+		return re
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"pattern": pattern, "error": err}).Warn("invalid path rewrite pattern")
+	}
+	patterns[pattern] = re
+	return re
+}
+
+// setForwarded 设置X-Forwarded-Host、X-Forwarded-Proto及Forwarded。
+// X-Forwarded-For由ReverseProxy追加客户端地址，不信任客户端时先删除客户端传入的值。
+func setForwarded(req *http.Request, in *http.Request) {
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	clientIP, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		clientIP = in.RemoteAddr
+	}
+
+	forwarded := forwardedElement(clientIP, in.Host, proto)
+	if config.GetConfig().Proxy.TrustForwarded {
+		if prior := in.Header.Values("Forwarded"); len(prior) > 0 {
+			forwarded = strings.Join(prior, ", ") + ", " + forwarded
+		}
+		if req.Header.Get("X-Forwarded-Host") == "" {
+			req.Header.Set("X-Forwarded-Host", in.Host)
+		}
+		if req.Header.Get("X-Forwarded-Proto") == "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+	} else {
+		req.Header.Del("X-Forwarded-For")
+		req.Header.Set("X-Forwarded-Host", in.Host)
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+	req.Header.Set("Forwarded", forwarded)
+}
+
+// forwardedElement 返回RFC 7239中的一个转发记录，IPv6地址需要加引号及方括号
+func forwardedElement(clientIP string, host string, proto string) string {
+	node := clientIP
+	if strings.Contains(node, ":") {
+		node = `"[` + node + `]"`
+	}
+	return "for=" + node + ";host=" + quoteForwarded(host) + ";proto=" + proto
+}
+
+func quoteForwarded(v string) string {
+	if strings.ContainsAny(v, `:[]" ,;=`) {
+		return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+	}
+	return v
+}
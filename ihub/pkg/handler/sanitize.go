@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"ihub/pkg/config"
+)
+
+// alwaysDenied 总是从上游响应中删除的响应头，避免泄露上游的服务器及框架版本
+var alwaysDenied = []string{"Server", "X-Powered-By"}
+
+// essentialHeaders 配置了Allow时仍然保留的响应头，删除后响应无法正确解析或缓存
+var essentialHeaders = []string{
+	"Content-*", "Transfer-Encoding", "Trailer", "Date", "Cache-Control", "Expires", "Etag",
+	"Last-Modified", "Vary", "Location", "Retry-After", "Grpc-*",
+}
+
+// sanitizeResponse 按配置过滤上游响应头并添加安全响应头。
+// 网关处理了跨域请求时删除上游返回的Access-Control-*，避免与网关的响应头重复。
+func sanitizeResponse(resp *http.Response, route *config.RouteConfig, corsHandled bool) {
+	cfg := config.GetConfig()
+	h := resp.Header
+	for _, name := range alwaysDenied {
+		h.Del(name)
+	}
+	for name := range h {
+		if headerMatch(cfg.Proxy.ResponseHeaders.Deny, name) ||
+			(corsHandled && strings.HasPrefix(name, "Access-Control-")) ||
+			(len(cfg.Proxy.ResponseHeaders.Allow) > 0 &&
+				!headerMatch(cfg.Proxy.ResponseHeaders.Allow, name) && !headerMatch(essentialHeaders, name)) {
+			delete(h, name)
+		}
+	}
+
+	csp := cfg.Security.CSP
+	if route != nil && route.CSP != "" {
+		csp = route.CSP
+	}
+	for name, value := range map[string]string{
+		"Strict-Transport-Security": cfg.Security.HSTS,
+		"X-Content-Type-Options":    cfg.Security.ContentTypeOptions,
+		"X-Frame-Options":           cfg.Security.FrameOptions,
+		"Referrer-Policy":           cfg.Security.ReferrerPolicy,
+		"Content-Security-Policy":   csp,
+	} {
+		if value != "" && h.Get(name) == "" {
+			h.Set(name, value)
+		}
+	}
+}
+
+// headerMatch 判断响应头是否在列表中，列表中以*结尾的名称按前缀匹配，不区分大小写
+func headerMatch(list []string, name string) bool {
+	for _, pattern := range list {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Status 单个集群网关或模块的健康状态
+type Status struct {
+	Cluster string `json:"cluster"`
+	Domain  string `json:"domain"`
+	// Module 为空时表示集群网关
+	Module    string    `json:"module,omitempty"`
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"failures"`
+	Successes int       `json:"successes"`
+	LastError string    `json:"lastError,omitempty"`
+	LastCheck time.Time `json:"lastCheck"`
+}
+
+type key struct {
+	domain string
+	module string
+}
+
+var (
+	mu    sync.RWMutex
+	table = map[key]*Status{}
+)
+
+// Start 启动后台探测，按health.interval周期检查所有已注册集群
+func Start() {
+	go func() {
+		for {
+			cfg := config.GetConfig().Health
+			if cfg.Enabled {
+				probeAll(cfg)
+			}
+			interval := cfg.Interval
+			if interval <= 0 {
+				interval = 10 * time.Second
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// probeAll 并发检查所有集群的网关及配置的模块
+func probeAll(cfg config.HealthConfig) {
+	clusters, err := db.GetClusters(context.Background())
+	if err != nil {
+		logrus.WithField("error", err).Warn("health check: list clusters failed")
+		return
+	}
+	client := &http.Client{Timeout: cfg.Timeout}
+	seen := map[key]bool{}
+	var wg sync.WaitGroup
+	for _, cluster := range clusters {
+		targets := []config.ModuleHealthConfig{{Module: "", Path: cfg.ClusterPath}}
+		targets = append(targets, cfg.Modules...)
+		for _, target := range targets {
+			k := key{cluster.Domain, target.Module}
+			seen[k] = true
+			wg.Add(1)
+			go func(cluster db.NameDomainId, target config.ModuleHealthConfig) {
+				defer wg.Done()
+				url := probeURL(cfg, cluster.Domain, target)
+				update(cfg, cluster, target.Module, url, probe(client, url))
+			}(cluster, target)
+		}
+	}
+	wg.Wait()
+
+	// 删除已注销的集群
+	mu.Lock()
+	for k := range table {
+		if !seen[k] {
+			delete(table, k)
+		}
+	}
+	mu.Unlock()
+}
+
+// probeURL 集群网关的检查地址为http://<clusterModule>.default.<domain><clusterPath>，
+// 模块的检查地址为http://<module>.default.<domain><path>
+func probeURL(cfg config.HealthConfig, domain string, target config.ModuleHealthConfig) string {
+	module := target.Module
+	if module == "" {
+		module = cfg.ClusterModule
+	}
+	return fmt.Sprintf("http://%s.default.%s%s", module, domain, target.Path)
+}
+
+// probe 请求成功且状态码小于500时认为健康
+func probe(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// update 更新健康状态，连续失败UnhealthyThreshold次后标记为不健康，连续成功HealthyThreshold次后恢复
+func update(cfg config.HealthConfig, cluster db.NameDomainId, module string, url string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	k := key{cluster.Domain, module}
+	st, ok := table[k]
+	if !ok {
+		st = &Status{Cluster: cluster.Name, Domain: cluster.Domain, Module: module, Healthy: true}
+		table[k] = st
+	}
+	st.URL = url
+	st.LastCheck = time.Now()
+	if err != nil {
+		st.Failures++
+		st.Successes = 0
+		st.LastError = err.Error()
+		if st.Healthy && st.Failures >= atLeastOne(cfg.UnhealthyThreshold) {
+			st.Healthy = false
+			logrus.WithFields(logrus.Fields{"cluster": st.Cluster, "module": module, "url": url}).Warn("health check: marked unhealthy: ", err)
+		}
+		return
+	}
+	st.Successes++
+	st.Failures = 0
+	st.LastError = ""
+	if !st.Healthy && st.Successes >= atLeastOne(cfg.HealthyThreshold) {
+		st.Healthy = true
+		logrus.WithFields(logrus.Fields{"cluster": st.Cluster, "module": module, "url": url}).Info("health check: marked healthy")
+	}
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Check 检查集群网关及模块是否健康，不健康时返回最近一次的错误，未探测过的视为健康
+func Check(domain string, module string) error {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, k := range []key{{domain, ""}, {domain, module}} {
+		if st, ok := table[k]; ok && !st.Healthy {
+			if k.module == "" {
+				return fmt.Errorf("集群网关不可用: %s", st.LastError)
+			}
+			return fmt.Errorf("模块%s不可用: %s", module, st.LastError)
+		}
+	}
+	return nil
+}
+
+// Snapshot 返回健康状态表，按集群、模块排序
+func Snapshot() []Status {
+	mu.RLock()
+	statuses := make([]Status, 0, len(table))
+	for _, st := range table {
+		statuses = append(statuses, *st)
+	}
+	mu.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Cluster != statuses[j].Cluster {
+			return statuses[i].Cluster < statuses[j].Cluster
+		}
+		if statuses[i].Domain != statuses[j].Domain {
+			return statuses[i].Domain < statuses[j].Domain
+		}
+		return statuses[i].Module < statuses[j].Module
+	})
+	return statuses
+}
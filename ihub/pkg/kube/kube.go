@@ -0,0 +1,280 @@
+package kube
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 集群内ServiceAccount的默认文件
+const (
+	DefaultTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	DefaultNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// errGone watch的resourceVersion已经过期，需要重新list
+var errGone = errors.New("resource version too old")
+
+// ObjectMeta .
+type ObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// Object 只解析需要的字段，ConfigMap使用Data，自定义资源使用Spec
+type Object struct {
+	Kind     string            `json:"kind"`
+	Metadata ObjectMeta        `json:"metadata"`
+	Data     map[string]string `json:"data"`
+	Spec     json.RawMessage   `json:"spec"`
+}
+
+type objectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []Object `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// status watch返回ERROR时的对象
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Client 通过REST接口访问apiserver，只支持list及watch
+type Client struct {
+	server    string
+	tokenFile string
+	http      *http.Client
+}
+
+// NewClient 创建访问apiserver的客户端，server为空时使用集群内的地址，
+// tokenFile、caFile为空时使用ServiceAccount的默认文件
+func NewClient(server string, tokenFile string, caFile string) (*Client, error) {
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("apiServer is not set and not running in a cluster")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+	if tokenFile == "" {
+		tokenFile = DefaultTokenFile
+	}
+	if caFile == "" {
+		caFile = DefaultCAFile
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ca, err := os.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%s: no certificate found", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &Client{
+		server:    strings.TrimSuffix(server, "/"),
+		tokenFile: tokenFile,
+		http:      &http.Client{Transport: transport},
+	}, nil
+}
+
+// Namespace 返回Pod所在的命名空间
+func Namespace() string {
+	b, err := os.ReadFile(DefaultNamespaceFile)
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// token会定期轮换，每次请求重新读取
+	if token, err := os.ReadFile(c.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusGone {
+			return nil, errGone
+		}
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// List 返回路径下的全部对象及resourceVersion
+func (c *Client) List(ctx context.Context, path string, query url.Values) ([]Object, string, error) {
+	resp, err := c.get(ctx, path, query)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var list objectList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("GET %s: %w", path, err)
+	}
+	return list.Items, list.Metadata.ResourceVersion, nil
+}
+
+// Watcher 先list再watch路径下的对象，在本地保存全部对象，每次变化后回调全部对象
+type Watcher struct {
+	Client *Client
+	// Path 如/api/v1/namespaces/default/configmaps
+	Path string
+	// Query 如fieldSelector=metadata.name=ihub
+	Query url.Values
+	// OnChange 对象有变化时回调按名称排序的全部对象
+	OnChange func([]Object)
+
+	objects map[string]Object
+}
+
+// Sync list一次并回调，用于启动时同步加载
+func (w *Watcher) Sync(ctx context.Context) (string, error) {
+	items, rv, err := w.Client.List(ctx, w.Path, w.Query)
+	if err != nil {
+		return "", err
+	}
+	w.objects = map[string]Object{}
+	for _, o := range items {
+		w.objects[o.Metadata.Name] = o
+	}
+	w.notify()
+	return rv, nil
+}
+
+// Run 持续watch直到ctx结束，连接断开时从上次的resourceVersion继续，过期时重新list。
+// resourceVersion为空时先list。
+func (w *Watcher) Run(ctx context.Context, resourceVersion string) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		var err error
+		if resourceVersion == "" {
+			resourceVersion, err = w.Sync(ctx)
+		}
+		if err == nil {
+			resourceVersion, err = w.watch(ctx, resourceVersion)
+		}
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+		if errors.Is(err, errGone) {
+			resourceVersion = ""
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.WithFields(logrus.Fields{"path": w.Path, "error": err}).Warn("watch kubernetes resource failed")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// watch 处理一次watch请求中的事件，返回最新的resourceVersion
+func (w *Watcher) watch(ctx context.Context, resourceVersion string) (string, error) {
+	query := url.Values{}
+	for k, v := range w.Query {
+		query[k] = v
+	}
+	query.Set("watch", "true")
+	query.Set("resourceVersion", resourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", "300")
+	resp, err := w.Client.get(ctx, w.Path, query)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer resp.Body.Close()
+
+	if w.objects == nil {
+		w.objects = map[string]Object{}
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var ev watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return resourceVersion, err
+		}
+		if ev.Type == "ERROR" {
+			var st status
+			json.Unmarshal(ev.Object, &st)
+			if st.Code == http.StatusGone {
+				return resourceVersion, errGone
+			}
+			return resourceVersion, fmt.Errorf("watch %s: %d %s", w.Path, st.Code, st.Message)
+		}
+		var obj Object
+		if err := json.Unmarshal(ev.Object, &obj); err != nil {
+			return resourceVersion, err
+		}
+		resourceVersion = obj.Metadata.ResourceVersion
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			w.objects[obj.Metadata.Name] = obj
+		case "DELETED":
+			delete(w.objects, obj.Metadata.Name)
+		default:
+			// BOOKMARK只更新resourceVersion
+			continue
+		}
+		w.notify()
+	}
+	return resourceVersion, scanner.Err()
+}
+
+func (w *Watcher) notify() {
+	names := make([]string, 0, len(w.objects))
+	for name := range w.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	objs := make([]Object, len(names))
+	for i, name := range names {
+		objs[i] = w.objects[name]
+	}
+	w.OnChange(objs)
+}
@@ -0,0 +1,218 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const configMapsPath = "/api/v1/namespaces/ns/configmaps"
+
+// apiserver apiserver的替身，按顺序处理list及watch请求，记录每个请求
+type apiserver struct {
+	t    *testing.T
+	srv  *httptest.Server
+	stop chan struct{}
+
+	mu       sync.Mutex
+	requests []*http.Request
+	lists    []func(w http.ResponseWriter)
+	watches  []func(w http.ResponseWriter)
+}
+
+func newAPIServer(t *testing.T) *apiserver {
+	a := &apiserver{t: t, stop: make(chan struct{})}
+	a.srv = httptest.NewServer(http.HandlerFunc(a.serve))
+	// 先结束挂起的watch请求，再关闭服务
+	t.Cleanup(a.srv.Close)
+	t.Cleanup(func() { close(a.stop) })
+	return a
+}
+
+func (a *apiserver) serve(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.requests = append(a.requests, r)
+	var handle func(http.ResponseWriter)
+	if r.URL.Query().Get("watch") == "true" {
+		if len(a.watches) > 0 {
+			handle, a.watches = a.watches[0], a.watches[1:]
+		}
+	} else if len(a.lists) > 0 {
+		handle, a.lists = a.lists[0], a.lists[1:]
+	}
+	a.mu.Unlock()
+	if r.URL.Path != configMapsPath {
+		http.NotFound(w, r)
+		return
+	}
+	if handle == nil {
+		// 没有安排的请求一直挂起，直到测试结束
+		select {
+		case <-r.Context().Done():
+		case <-a.stop:
+		}
+		return
+	}
+	handle(w)
+}
+
+func (a *apiserver) history() []*http.Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*http.Request{}, a.requests...)
+}
+
+func list(rv string, objs ...Object) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		var l objectList
+		l.Metadata.ResourceVersion = rv
+		l.Items = objs
+		json.NewEncoder(w).Encode(l)
+	}
+}
+
+// events 以换行分隔的JSON返回watch事件，与apiserver的格式相同
+func events(evs ...string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for _, ev := range evs {
+			fmt.Fprintln(w, ev)
+		}
+	}
+}
+
+func event(typ string, obj interface{}) string {
+	b, _ := json.Marshal(obj)
+	ev, _ := json.Marshal(watchEvent{Type: typ, Object: b})
+	return string(ev)
+}
+
+func configMap(name string, rv string, value string) Object {
+	return Object{
+		Kind:     "ConfigMap",
+		Metadata: ObjectMeta{Name: name, Namespace: "ns", ResourceVersion: rv},
+		Data:     map[string]string{"key": value},
+	}
+}
+
+func writeToken(t *testing.T, file string, token string) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// summary 将回调的对象转换为name=value列表，便于比较
+func summary(objs []Object) string {
+	var parts []string
+	for _, o := range objs {
+		parts = append(parts, o.Metadata.Name+"="+o.Data["key"])
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestWatcherRun(t *testing.T) {
+	a := newAPIServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeToken(t, tokenFile, "token-1")
+
+	a.lists = []func(http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			// token轮换，之后的请求使用新的token
+			writeToken(t, tokenFile, "token-2")
+			list("10", configMap("a", "9", "a1"), configMap("b", "10", "b1"))(w)
+		},
+		list("20", configMap("a", "20", "a3")),
+		list("30", configMap("a", "20", "a3"), configMap("c", "30", "c1")),
+	}
+	a.watches = []func(http.ResponseWriter){
+		events(
+			event("MODIFIED", configMap("a", "11", "a2")),
+			event("DELETED", configMap("b", "12", "b1")),
+			event("BOOKMARK", Object{Metadata: ObjectMeta{ResourceVersion: "13"}}),
+			event("ERROR", status{Code: http.StatusGone, Message: "too old resource version: 13"}),
+		),
+		func(w http.ResponseWriter) {
+			http.Error(w, `{"kind":"Status","code":410}`, http.StatusGone)
+		},
+	}
+
+	client, err := NewClient(a.srv.URL+"/", tokenFile, filepath.Join(t.TempDir(), "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan string, 16)
+	w := &Watcher{
+		Client:   client,
+		Path:     configMapsPath,
+		OnChange: func(objs []Object) { changes <- summary(objs) },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx, "")
+
+	want := []string{
+		"a=a1,b=b1", // 第一次list
+		"a=a2,b=b1", // MODIFIED
+		"a=a2",      // DELETED，BOOKMARK不回调
+		"a=a3",      // ERROR 410后重新list
+		"a=a3,c=c1", // watch返回410后重新list
+	}
+	for i, w := range want {
+		select {
+		case got := <-changes:
+			if got != w {
+				t.Fatalf("change %d = %q, want %q", i, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("change %d not received, want %q", i, w)
+		}
+	}
+
+	// 等待最后一次watch请求
+	deadline := time.Now().Add(5 * time.Second)
+	for len(a.history()) < 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var got []string
+	for _, r := range a.history() {
+		q := r.URL.Query()
+		kind := "list"
+		if q.Get("watch") == "true" {
+			kind = "watch@" + q.Get("resourceVersion")
+		}
+		got = append(got, kind+" "+r.Header.Get("Authorization"))
+	}
+	wantRequests := []string{
+		"list Bearer token-1",
+		"watch@10 Bearer token-2",
+		"list Bearer token-2",
+		"watch@20 Bearer token-2",
+		"list Bearer token-2",
+		"watch@30 Bearer token-2",
+	}
+	if strings.Join(got, "\n") != strings.Join(wantRequests, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(wantRequests, "\n"))
+	}
+}
+
+func TestClientListError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	client, err := NewClient(srv.URL, filepath.Join(t.TempDir(), "token"), filepath.Join(t.TempDir(), "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.List(context.Background(), configMapsPath, nil); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("List error = %v, want 403", err)
+	}
+}
@@ -0,0 +1,246 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/metrics"
+)
+
+// 请求优先级，过载时先拒绝低优先级请求
+const (
+	PriorityLow  = 0
+	PriorityHigh = 1
+)
+
+// minRTTResetInterval 自适应模式下最小响应时间的重新统计周期，避免上游变慢后一直按旧的基准计算
+const minRTTResetInterval = time.Minute
+
+// rttSmoothing 响应时间及并发数的平滑系数
+const rttSmoothing = 0.2
+
+var (
+	// ErrQueueFull 排队人数已满
+	ErrQueueFull = errors.New("queue is full")
+	// ErrQueueTimeout 排队超时
+	ErrQueueTimeout = errors.New("queue timeout")
+	// ErrEvicted 排队中被高优先级请求挤出
+	ErrEvicted = errors.New("evicted by higher priority request")
+)
+
+// waiter 排队中的请求，ch收到true表示获得执行机会，false表示被挤出队列
+type waiter struct {
+	ch chan bool
+}
+
+// Limiter 单个(集群、模块)的并发限制，超过限制的请求按优先级排队
+type Limiter struct {
+	mu       sync.Mutex
+	cluster  string
+	module   string
+	inflight int
+	// limit 当前的并发上限，自适应模式下根据响应时间调整
+	limit float64
+	high  []*waiter
+	low   []*waiter
+	// 自适应模式下的最小响应时间及平滑后的响应时间
+	minRTT      time.Duration
+	minRTTStart time.Time
+	rtt         time.Duration
+	// lastUsed 最近一次请求的时间，并发限制数达到上限时淘汰最久未使用的空闲并发限制
+	lastUsed time.Time
+}
+
+// Acquire 获取执行机会，成功时返回release，需要在请求结束后调用
+func (l *Limiter) Acquire(ctx context.Context, cfg config.ConcurrencyConfig, priority int) (func(), error) {
+	l.mu.Lock()
+	l.lastUsed = time.Now()
+	l.applyConfig(cfg)
+	if l.inflight < int(l.limit) && len(l.high)+len(l.low) == 0 {
+		l.inflight++
+		l.gauge()
+		l.mu.Unlock()
+		return l.releaseFunc(cfg), nil
+	}
+	if len(l.high)+len(l.low) >= cfg.QueueSize {
+		// 队列已满时，高优先级请求挤掉最后一个低优先级请求
+		if priority != PriorityHigh || len(l.low) == 0 {
+			l.mu.Unlock()
+			return nil, ErrQueueFull
+		}
+		evicted := l.low[len(l.low)-1]
+		l.low = l.low[:len(l.low)-1]
+		evicted.ch <- false
+	}
+	w := &waiter{ch: make(chan bool, 1)}
+	if priority == PriorityHigh {
+		l.high = append(l.high, w)
+	} else {
+		l.low = append(l.low, w)
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case ok := <-w.ch:
+		if !ok {
+			return nil, ErrEvicted
+		}
+		return l.releaseFunc(cfg), nil
+	case <-timer.C:
+		return nil, l.cancel(w, cfg, ErrQueueTimeout)
+	case <-ctx.Done():
+		return nil, l.cancel(w, cfg, ctx.Err())
+	}
+}
+
+// cancel 排队超时或客户端取消时离开队列，如果已经获得执行机会则释放
+func (l *Limiter) cancel(w *waiter, cfg config.ConcurrencyConfig, err error) error {
+	l.mu.Lock()
+	removed := remove(&l.high, w) || remove(&l.low, w)
+	l.mu.Unlock()
+	if !removed {
+		if ok := <-w.ch; ok {
+			l.releaseFunc(cfg)()
+		}
+	}
+	return err
+}
+
+func remove(queue *[]*waiter, w *waiter) bool {
+	for i, q := range *queue {
+		if q == w {
+			*queue = append((*queue)[:i], (*queue)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// releaseFunc 请求结束后释放执行机会并唤醒排队的请求，自适应模式下根据响应时间调整并发上限
+func (l *Limiter) releaseFunc(cfg config.ConcurrencyConfig) func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.inflight--
+			if cfg.Adaptive {
+				l.adapt(cfg, time.Since(start))
+			}
+			for l.inflight < int(l.limit) {
+				var next *waiter
+				if len(l.high) > 0 {
+					next, l.high = l.high[0], l.high[1:]
+				} else if len(l.low) > 0 {
+					next, l.low = l.low[0], l.low[1:]
+				} else {
+					break
+				}
+				l.inflight++
+				next.ch <- true
+			}
+			l.gauge()
+		})
+	}
+}
+
+// applyConfig 非自适应模式下并发上限即配置的MaxInflight，自适应模式下限制在[MinInflight, MaxInflight]之间
+func (l *Limiter) applyConfig(cfg config.ConcurrencyConfig) {
+	if !cfg.Adaptive || l.limit == 0 {
+		l.limit = float64(cfg.MaxInflight)
+	}
+	l.limit = math.Max(float64(minInflight(cfg)), math.Min(float64(cfg.MaxInflight), l.limit))
+}
+
+// adapt 梯度算法：gradient = minRTT / rtt，响应时间变长时降低并发上限，
+// 响应时间接近最小值时按sqrt(limit)逐步增加
+func (l *Limiter) adapt(cfg config.ConcurrencyConfig, sample time.Duration) {
+	now := time.Now()
+	if l.minRTT == 0 || sample < l.minRTT || now.Sub(l.minRTTStart) > minRTTResetInterval {
+		if now.Sub(l.minRTTStart) > minRTTResetInterval {
+			l.minRTTStart = now
+		}
+		l.minRTT = sample
+	}
+	if l.rtt == 0 {
+		l.rtt = sample
+	} else {
+		l.rtt = time.Duration((1-rttSmoothing)*float64(l.rtt) + rttSmoothing*float64(sample))
+	}
+	if l.rtt <= 0 {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1, float64(l.minRTT)/float64(l.rtt)))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = (1-rttSmoothing)*l.limit + rttSmoothing*newLimit
+	l.limit = math.Max(float64(minInflight(cfg)), math.Min(float64(cfg.MaxInflight), l.limit))
+}
+
+func (l *Limiter) gauge() {
+	metrics.ConcurrencyInflight.WithLabelValues(metrics.Cluster(l.cluster), metrics.Module(l.module)).Set(float64(l.inflight))
+	metrics.ConcurrencyLimit.WithLabelValues(metrics.Cluster(l.cluster), metrics.Module(l.module)).Set(math.Floor(l.limit))
+}
+
+// minInflight 并发上限的下限，至少为1
+func minInflight(cfg config.ConcurrencyConfig) int {
+	if cfg.MinInflight < 1 {
+		return 1
+	}
+	return cfg.MinInflight
+}
+
+type key struct {
+	cluster string
+	module  string
+}
+
+// maxLimiters 最多保存的并发限制数。模块名称来自请求路径，不限制时随机路径会使并发限制无限增加
+const maxLimiters = 10000
+
+var (
+	mu       sync.Mutex
+	limiters = map[key]*Limiter{}
+)
+
+// Get 返回(集群、模块)对应的并发限制，不存在时创建，并发限制数达到上限时先淘汰最久未使用的空闲并发限制
+func Get(cluster string, module string) *Limiter {
+	mu.Lock()
+	defer mu.Unlock()
+	k := key{cluster, module}
+	l, ok := limiters[k]
+	if !ok {
+		if len(limiters) >= maxLimiters {
+			evict()
+		}
+		now := time.Now()
+		l = &Limiter{cluster: cluster, module: module, minRTTStart: now, lastUsed: now}
+		limiters[k] = l
+	}
+	return l
+}
+
+// evict 淘汰最久未使用的空闲并发限制，有请求执行或排队的并发限制不淘汰，调用方需持有mu。
+// 全部都有请求时不淘汰，此时并发限制数受正在处理的请求数限制
+func evict() {
+	var oldest *key
+	var oldestAt time.Time
+	for k, l := range limiters {
+		k := k
+		l.mu.Lock()
+		used, idle := l.lastUsed, l.inflight == 0 && len(l.high)+len(l.low) == 0
+		l.mu.Unlock()
+		if idle && (oldest == nil || used.Before(oldestAt)) {
+			oldest, oldestAt = &k, used
+		}
+	}
+	if oldest != nil {
+		delete(limiters, *oldest)
+	}
+}
@@ -0,0 +1,41 @@
+package limiter
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"ihub/pkg/config"
+)
+
+// inflight ihub整体正在处理的请求数
+var inflight int64
+
+// Priority 查询类请求(GET、HEAD、OPTIONS)为低优先级，增删改请求为高优先级
+func Priority(method string) int {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return PriorityLow
+	}
+	return PriorityHigh
+}
+
+// PriorityName 返回优先级的名称，用于监控指标
+func PriorityName(priority int) string {
+	if priority == PriorityHigh {
+		return "high"
+	}
+	return "low"
+}
+
+// Enter 记录一个正在处理的请求，ihub过载时返回false。
+// 正在处理的请求数超过LowPriorityInflight时只接受高优先级请求，超过MaxInflight时全部拒绝。
+// 返回true时需要在请求结束后调用返回的函数。
+func Enter(cfg config.SheddingConfig, priority int) (func(), bool) {
+	n := atomic.AddInt64(&inflight, 1)
+	if (cfg.MaxInflight > 0 && n > int64(cfg.MaxInflight)) ||
+		(priority == PriorityLow && cfg.LowPriorityInflight > 0 && n > int64(cfg.LowPriorityInflight)) {
+		atomic.AddInt64(&inflight, -1)
+		return nil, false
+	}
+	return func() { atomic.AddInt64(&inflight, -1) }, true
+}
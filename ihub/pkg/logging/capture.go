@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 临时全量记录默认及最长的持续时间，到期后自动失效，避免忘记关闭
+const (
+	defaultCaptureDuration = 10 * time.Minute
+	maxCaptureDuration     = time.Hour
+)
+
+// Capture 通过管理接口临时开启的全量记录，指定用户或追踪ID的请求按Trace级别记录请求/响应头及请求/响应体，
+// 不受模块日志级别及采样的影响。网关重启后失效。
+type Capture struct {
+	ID      string    `json:"id"`
+	UserID  string    `json:"userId,omitempty"`
+	TraceID string    `json:"traceId,omitempty"`
+	Until   time.Time `json:"until"`
+	// CreatedBy 开启全量记录的管理员，没有配置管理员令牌时为客户端地址
+	CreatedBy string `json:"createdBy"`
+}
+
+var captures struct {
+	mu   sync.RWMutex
+	seq  int
+	list []Capture
+}
+
+// AddCapture 为用户或追踪ID开启全量记录，duration为0时使用默认的10分钟，最长1小时。
+// by为开启的管理员，与开启的记录一起写入日志
+func AddCapture(userID string, traceID string, duration time.Duration, by string) (Capture, error) {
+	if userID == "" && traceID == "" {
+		return Capture{}, errors.New("userId or traceId is required")
+	}
+	if duration < 0 || duration > maxCaptureDuration {
+		return Capture{}, errors.New("duration must be between 0 and " + maxCaptureDuration.String())
+	}
+	if duration == 0 {
+		duration = defaultCaptureDuration
+	}
+	captures.mu.Lock()
+	defer captures.mu.Unlock()
+	captures.seq++
+	cp := Capture{
+		ID:        strconv.Itoa(captures.seq),
+		UserID:    userID,
+		TraceID:   normalizeTraceID(traceID),
+		Until:     time.Now().Add(duration),
+		CreatedBy: by,
+	}
+	captures.list = append(removeExpired(captures.list), cp)
+	logrus.WithFields(logrus.Fields{"id": cp.ID, "userId": cp.UserID, "traceId": cp.TraceID, "until": cp.Until, "by": by}).
+		Warn("full log capture enabled")
+	return cp, nil
+}
+
+// Captures 返回未到期的全量记录
+func Captures() []Capture {
+	captures.mu.Lock()
+	defer captures.mu.Unlock()
+	captures.list = removeExpired(captures.list)
+	return append([]Capture{}, captures.list...)
+}
+
+// RemoveCapture 提前关闭全量记录，by为关闭的管理员，不存在时返回false
+func RemoveCapture(id string, by string) bool {
+	captures.mu.Lock()
+	defer captures.mu.Unlock()
+	for i, cp := range captures.list {
+		if cp.ID == id {
+			captures.list = append(captures.list[:i], captures.list[i+1:]...)
+			logrus.WithFields(logrus.Fields{"id": id, "by": by}).Info("full log capture removed")
+			return true
+		}
+	}
+	return false
+}
+
+// Captured 判断请求是否需要全量记录
+func Captured(userID string, traceID string) bool {
+	captures.mu.RLock()
+	defer captures.mu.RUnlock()
+	if len(captures.list) == 0 {
+		return false
+	}
+	now := time.Now()
+	traceID = normalizeTraceID(traceID)
+	for _, cp := range captures.list {
+		if now.After(cp.Until) {
+			continue
+		}
+		if (cp.UserID != "" && cp.UserID == userID) || (cp.TraceID != "" && cp.TraceID == traceID) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeExpired(list []Capture) []Capture {
+	now := time.Now()
+	out := list[:0]
+	for _, cp := range list {
+		if now.Before(cp.Until) {
+			out = append(out, cp)
+		}
+	}
+	return out
+}
+
+// normalizeTraceID X-Trace-ID为UUID格式，traceparent中为32位十六进制，两种格式都可以匹配
+func normalizeTraceID(traceID string) string {
+	return strings.ToLower(strings.ReplaceAll(traceID, "-", ""))
+}
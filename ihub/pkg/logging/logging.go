@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+
+	"github.com/sirupsen/logrus"
+)
+
+// timestampFormat 日志中的时间格式
+const timestampFormat = "2006-01-02 15:04:05"
+
+var (
+	// file 日志文件，访问日志及网关自身的日志共用
+	file = &rotatingFile{}
+	// access 访问日志，级别固定为Trace，由log中间件按模块判断是否记录
+	access = logrus.New()
+)
+
+// Init 按log配置打开日志文件，设置日志格式及级别。网关自身的日志(logrus)及访问日志都输出到标准输出及日志文件。
+// 配置热加载后重新设置，日志文件路径变化时打开新文件，打开失败时配置回滚。
+func Init() error {
+	if err := configure(config.GetConfig().LOG); err != nil {
+		return err
+	}
+	config.OnReload(func(ev config.ReloadEvent) error {
+		if ev.Old != nil && reflect.DeepEqual(ev.Old.LOG, ev.New.LOG) {
+			return nil
+		}
+		return configure(ev.New.LOG)
+	})
+	return nil
+}
+
+func configure(cfg config.LogConfig) error {
+	path := cfg.DefaultConfigName
+	if path == "" {
+		path = constants.DefaultLogName
+	}
+	if err := file.configure(path, cfg.Rotate); err != nil {
+		return err
+	}
+	formatter := newFormatter(cfg.Format)
+	out := io.MultiWriter(os.Stdout, file)
+	for _, l := range []*logrus.Logger{logrus.StandardLogger(), access} {
+		l.SetFormatter(formatter)
+		l.SetOutput(out)
+	}
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	logrus.SetLevel(level)
+	access.SetLevel(logrus.TraceLevel)
+	setRedactor(cfg)
+	return nil
+}
+
+func newFormatter(format string) logrus.Formatter {
+	if format == "json" {
+		return &logrus.JSONFormatter{TimestampFormat: timestampFormat}
+	}
+	return &logrus.TextFormatter{TimestampFormat: timestampFormat}
+}
+
+// Access 返回访问日志
+func Access() *logrus.Logger {
+	return access
+}
+
+// AccessLevel 返回模块访问日志的级别：log.modules中的配置优先，其次为log中间件配置的level，最后为log.level。
+// 都没有配置时与原来一样记录全部内容(Trace)。
+func AccessLevel(module string, midwareLevel string) logrus.Level {
+	cfg := config.GetConfig().LOG
+	name := cfg.Modules[strings.ToLower(module)]
+	if name == "" {
+		name = midwareLevel
+	}
+	if name == "" {
+		name = cfg.Level
+	}
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		return logrus.TraceLevel
+	}
+	return level
+}
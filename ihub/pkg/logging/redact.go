@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ihub/pkg/config"
+)
+
+// redacted 隐藏后的取值
+const redacted = "******"
+
+// 默认隐藏的请求/响应头及JSON字段，配置中的redactHeaders、redactFields在此基础上追加
+var (
+	defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Auth-Token", "X-Api-Key"}
+	defaultRedactFields  = []string{"password", "passwd", "pwd", "token", "access_token", "refresh_token", "secret", "credential"}
+)
+
+// redactor 按配置隐藏敏感的请求头及请求体字段，配置热加载后重新构造
+type redactor struct {
+	headers map[string]bool
+	// fields 匹配JSON中的"字段": 值，截断的JSON同样可以匹配
+	fields *regexp.Regexp
+}
+
+var (
+	redactorMu sync.RWMutex
+	redaction  = newRedactor(config.LogConfig{})
+)
+
+func newRedactor(cfg config.LogConfig) *redactor {
+	r := &redactor{headers: map[string]bool{}}
+	for _, h := range append(append([]string(nil), defaultRedactHeaders...), cfg.RedactHeaders...) {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	var names []string
+	for _, f := range append(append([]string(nil), defaultRedactFields...), cfg.RedactFields...) {
+		names = append(names, regexp.QuoteMeta(f))
+	}
+	// 字符串取值匹配到结束的引号(或截断处)，数字等取值匹配到下一个分隔符，对象及数组中的字段单独匹配
+	r.fields = regexp.MustCompile(`(?i)("(?:` + strings.Join(names, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,{}\[\]\s]+)`)
+	return r
+}
+
+func setRedactor(cfg config.LogConfig) {
+	r := newRedactor(cfg)
+	redactorMu.Lock()
+	redaction = r
+	redactorMu.Unlock()
+}
+
+func getRedactor() *redactor {
+	redactorMu.RLock()
+	defer redactorMu.RUnlock()
+	return redaction
+}
+
+// RedactHeader 返回隐藏了敏感取值的请求/响应头副本，用于记录日志，原请求头不变
+func RedactHeader(header http.Header) http.Header {
+	r := getRedactor()
+	out := make(http.Header, len(header))
+	for k, v := range header {
+		if r.headers[http.CanonicalHeaderKey(k)] {
+			out[k] = []string{redacted}
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// RedactBody 隐藏JSON请求/响应体中敏感字段的取值，不是JSON时原样返回
+func RedactBody(body string) string {
+	if !strings.Contains(body, `"`) {
+		return body
+	}
+	return getRedactor().fields.ReplaceAllString(body, `${1}"`+redacted+`"`)
+}
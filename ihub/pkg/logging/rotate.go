@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+)
+
+// backupTimeFormat 轮转文件名中的时间，如ihub-20230301T150405.000.log
+const backupTimeFormat = "20060102T150405.000"
+
+// rotatingFile 按大小或时间轮转的日志文件，访问日志及网关自身的日志共用同一个文件
+type rotatingFile struct {
+	mu     sync.Mutex
+	path   string
+	rotate config.LogRotateConfig
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// open 打开日志文件，文件不存在时创建，已经打开的文件先关闭
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// configure 应用新的配置，路径变化时打开新的文件
+func (f *rotatingFile) configure(path string, rotate config.LogRotateConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rotate = rotate
+	if f.file != nil && f.path == path {
+		return nil
+	}
+	old := f.path
+	f.path = path
+	if err := f.open(); err != nil {
+		f.path = old
+		return err
+	}
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return len(p), nil
+	}
+	if f.shouldRotate(len(p)) {
+		// 轮转失败时继续写入原文件，不丢失日志
+		if err := f.rotateFile(); err != nil {
+			os.Stderr.WriteString("rotate log file failed: " + err.Error() + "\n")
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.rotate.MaxSize > 0 && f.size+int64(n) > f.rotate.MaxSize {
+		return true
+	}
+	return f.rotate.Interval > 0 && time.Since(f.opened) >= f.rotate.Interval
+}
+
+// rotateFile 将当前文件重命名为带时间的轮转文件，打开新文件后按保留配置删除旧的轮转文件
+func (f *rotatingFile) rotateFile() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if err := os.Rename(f.path, backupName(f.path, time.Now())); err != nil {
+		// 重命名失败时重新打开原文件
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.removeBackups()
+	return nil
+}
+
+// removeBackups 删除超过maxBackups个或超过maxAge的轮转文件
+func (f *rotatingFile) removeBackups() {
+	if f.rotate.MaxBackups <= 0 && f.rotate.MaxAge <= 0 {
+		return
+	}
+	backups := listBackups(f.path)
+	for i, b := range backups {
+		if (f.rotate.MaxBackups > 0 && i >= f.rotate.MaxBackups) ||
+			(f.rotate.MaxAge > 0 && time.Since(b.time) > f.rotate.MaxAge) {
+			os.Remove(b.path)
+		}
+	}
+}
+
+func (f *rotatingFile) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// backupName ihub.log轮转后为ihub-<时间>.log
+func backupName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+type backup struct {
+	path string
+	time time.Time
+}
+
+// listBackups 返回日志文件的轮转文件，按时间从新到旧排序
+func listBackups(path string) []backup {
+	ext := filepath.Ext(path)
+	prefix := filepath.Base(strings.TrimSuffix(path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(filepath.Dir(path), name), time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+	return backups
+}
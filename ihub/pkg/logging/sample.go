@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"ihub/pkg/config"
+)
+
+// Sampled 按log.sampling判断是否记录请求的访问日志，使用第一条匹配的规则，没有匹配的规则时记录
+func Sampled(module string, method string, status int) bool {
+	for _, rule := range config.GetConfig().LOG.Sampling {
+		if !sampleRuleMatch(rule, module, method, status) {
+			continue
+		}
+		return rule.Rate >= 1 || (rule.Rate > 0 && rand.Float64() < rule.Rate)
+	}
+	return true
+}
+
+func sampleRuleMatch(rule config.LogSamplingRule, module string, method string, status int) bool {
+	if len(rule.Modules) > 0 && !containsFold(rule.Modules, module) {
+		return false
+	}
+	if len(rule.Methods) > 0 && !containsFold(rule.Methods, method) {
+		return false
+	}
+	if len(rule.Statuses) == 0 {
+		return true
+	}
+	code := strconv.Itoa(status)
+	for _, s := range rule.Statuses {
+		// 5xx匹配500到599
+		if strings.EqualFold(s, code) || (len(code) == 3 && strings.EqualFold(s, code[:1]+"xx")) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
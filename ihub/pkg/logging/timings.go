@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type timingsKey struct{}
+
+// Timings 一个请求在数据库及上游花费的时间，log中间件在请求开始时创建，
+// 访问数据库及转发到上游时累加，慢请求日志中记录各部分的耗时
+type Timings struct {
+	db        atomic.Int64
+	dbQueries atomic.Int64
+	upstream  atomic.Int64
+}
+
+// WithTimings 返回带有Timings的上下文
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+func timingsFrom(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// AddDBTime 累加一次数据库访问的耗时，上下文中没有Timings时忽略
+func AddDBTime(ctx context.Context, d time.Duration) {
+	if t := timingsFrom(ctx); t != nil {
+		t.db.Add(int64(d))
+		t.dbQueries.Add(1)
+	}
+}
+
+// AddUpstreamTime 累加转发到上游的耗时，包括重试及返回响应体的时间，上下文中没有Timings时忽略
+func AddUpstreamTime(ctx context.Context, d time.Duration) {
+	if t := timingsFrom(ctx); t != nil {
+		t.upstream.Add(int64(d))
+	}
+}
+
+// Breakdown 返回慢请求日志中的耗时字段，total为请求的总耗时，其余时间计为中间件的耗时
+func (t *Timings) Breakdown(total time.Duration) logrus.Fields {
+	db := time.Duration(t.db.Load())
+	upstream := time.Duration(t.upstream.Load())
+	midware := total - db - upstream
+	if midware < 0 {
+		midware = 0
+	}
+	return logrus.Fields{
+		"db_time":       db,
+		"db_queries":    t.dbQueries.Load(),
+		"upstream_time": upstream,
+		"midware_time":  midware,
+	}
+}
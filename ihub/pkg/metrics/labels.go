@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Other 超出限制的标签取值统一记为other，避免标签取值随请求无限增长
+const Other = "other"
+
+// Limits 标签取值的限制
+type Limits struct {
+	// Clusters 集群标签的白名单，为空时记录最先出现的MaxClusters个集群
+	Clusters    []string
+	MaxClusters int
+	// Modules 配置中出现的模块，总是单独记录；其他模块最多记录MaxModules个
+	Modules    []string
+	MaxModules int
+	// MaxEndpoints 每个模块最多记录的接口数，EndpointDepth 接口保留的路径层数
+	MaxEndpoints  int
+	EndpointDepth int
+}
+
+var (
+	labelMu sync.Mutex
+	limits  Limits
+	// allowedClusters、knownModules 由配置决定，seen* 为按出现顺序记录的取值
+	allowedClusters = map[string]bool{}
+	knownModules    = map[string]bool{}
+	seenClusters    = map[string]bool{}
+	seenModules     = map[string]bool{}
+	seenEndpoints   = map[string]map[string]bool{}
+
+	idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{16,}|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$`)
+)
+
+// SetLimits 设置标签取值的限制，配置热加载后重新设置，已经记录的取值保留
+func SetLimits(l Limits) {
+	labelMu.Lock()
+	defer labelMu.Unlock()
+	limits = l
+	allowedClusters = map[string]bool{}
+	for _, c := range l.Clusters {
+		allowedClusters[c] = true
+	}
+	knownModules = map[string]bool{}
+	for _, m := range l.Modules {
+		knownModules[strings.ToLower(m)] = true
+	}
+}
+
+// Cluster 返回集群标签，配置了白名单时只记录白名单中的集群
+func Cluster(name string) string {
+	if name == "" {
+		return ""
+	}
+	labelMu.Lock()
+	defer labelMu.Unlock()
+	if len(limits.Clusters) > 0 {
+		if allowedClusters[name] {
+			return name
+		}
+		return Other
+	}
+	return bounded(seenClusters, name, limits.MaxClusters)
+}
+
+// Module 返回模块标签，配置中出现的模块总是单独记录
+func Module(name string) string {
+	if name == "" {
+		return ""
+	}
+	labelMu.Lock()
+	defer labelMu.Unlock()
+	if knownModules[strings.ToLower(name)] {
+		return name
+	}
+	return bounded(seenModules, name, limits.MaxModules)
+}
+
+// Endpoint 返回接口标签：只保留路径的前EndpointDepth层，数字、UUID等ID替换为:id，每个模块最多记录MaxEndpoints个
+func Endpoint(module string, path string) string {
+	if module == Other {
+		return Other
+	}
+	labelMu.Lock()
+	defer labelMu.Unlock()
+	var parts []string
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		if len(parts) == limits.EndpointDepth {
+			parts = append(parts, "*")
+			break
+		}
+		if idSegment.MatchString(seg) {
+			seg = ":id"
+		}
+		parts = append(parts, seg)
+	}
+	endpoint := "/" + strings.Join(parts, "/")
+	seen, ok := seenEndpoints[module]
+	if !ok {
+		seen = map[string]bool{}
+		seenEndpoints[module] = seen
+	}
+	return bounded(seen, endpoint, limits.MaxEndpoints)
+}
+
+// Method 返回请求方法标签，非标准方法记为other
+func Method(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return Other
+}
+
+// StatusClass 返回状态码类别，如2xx
+func StatusClass(code int) string {
+	if code < 100 || code > 599 {
+		return Other
+	}
+	return string(rune('0'+code/100)) + "xx"
+}
+
+// bounded 记录最先出现的max个取值，之后出现的新取值记为other，调用方需持有labelMu
+func bounded(seen map[string]bool, value string, max int) string {
+	if seen[value] {
+		return value
+	}
+	if len(seen) >= max {
+		return Other
+	}
+	seen[value] = true
+	return value
+}
@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProxyUpstreamErrors 代理到上游模块失败的次数，按错误类型、集群、模块区分
+var ProxyUpstreamErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "proxy",
+		Name:      "upstream_errors_total",
+		Help:      "Number of failed upstream round trips by error kind.",
+	},
+	[]string{"kind", "cluster", "module"},
+)
+
+// ProxyRetries 代理重试次数，outcome为retried(已重试)或budget_exhausted(重试预算不足)
+var ProxyRetries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "proxy",
+		Name:      "retries_total",
+		Help:      "Number of upstream retries by outcome.",
+	},
+	[]string{"cluster", "module", "outcome"},
+)
+
+// BreakerState 熔断器状态，0为关闭，1为打开，2为半开
+var BreakerState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "ihub",
+		Subsystem: "breaker",
+		Name:      "state",
+		Help:      "Circuit breaker state (0 closed, 1 open, 2 half-open).",
+	},
+	[]string{"cluster", "module"},
+)
+
+// BreakerRejections 熔断器打开时被拒绝的请求数
+var BreakerRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "breaker",
+		Name:      "rejections_total",
+		Help:      "Number of requests rejected by an open circuit breaker.",
+	},
+	[]string{"cluster", "module"},
+)
+
+// RateLimitRejections 被限流的请求数，key为限流维度
+var RateLimitRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "ratelimit",
+		Name:      "rejections_total",
+		Help:      "Number of requests rejected by rate limiting.",
+	},
+	[]string{"key", "module"},
+)
+
+// ConcurrencyInflight 发往上游正在处理的请求数
+var ConcurrencyInflight = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "ihub",
+		Subsystem: "concurrency",
+		Name:      "inflight",
+		Help:      "Number of in-flight upstream requests.",
+	},
+	[]string{"cluster", "module"},
+)
+
+// ConcurrencyLimit 当前的并发上限，自适应模式下随响应时间变化
+var ConcurrencyLimit = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "ihub",
+		Subsystem: "concurrency",
+		Name:      "limit",
+		Help:      "Current concurrency limit of an upstream.",
+	},
+	[]string{"cluster", "module"},
+)
+
+// ConcurrencyRejections 因并发限制被拒绝的请求数，reason为queue_full、queue_timeout、evicted
+var ConcurrencyRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "concurrency",
+		Name:      "rejections_total",
+		Help:      "Number of requests rejected by upstream concurrency limits.",
+	},
+	[]string{"cluster", "module", "reason"},
+)
+
+// SheddingRejections ihub过载时被拒绝的请求数，priority为low或high
+var SheddingRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "shedding",
+		Name:      "rejections_total",
+		Help:      "Number of requests shed while ihub is overloaded.",
+	},
+	[]string{"priority"},
+)
+
+// CacheRequests 配置了缓存的路由的请求数，result为hit、miss、revalidated
+var CacheRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Number of requests to cached routes by result.",
+	},
+	[]string{"module", "result"},
+)
+
+// MirrorRequests 镜像请求数，result为ok、error、dropped，开启比较时为match(状态码一致)、mismatch
+var MirrorRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "mirror",
+		Name:      "requests_total",
+		Help:      "Number of mirrored requests by result.",
+	},
+	[]string{"module", "result"},
+)
+
+// CanaryRequests 灰度路由分配到各版本的请求数，reason为override(请求头、Cookie或用户指定)、sticky、weight
+var CanaryRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "canary",
+		Name:      "requests_total",
+		Help:      "Number of requests routed by canary rules.",
+	},
+	[]string{"module", "version", "reason"},
+)
+
+// ConfigReloads 配置热加载次数，result为success、failure(检查失败或中间件链重建失败)、rollback
+var ConfigReloads = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "config",
+		Name:      "reloads_total",
+		Help:      "Number of config reloads by result.",
+	},
+	[]string{"result"},
+)
+
+// ConfigLastReload 最近一次成功切换配置的时间戳
+var ConfigLastReload = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "ihub",
+		Subsystem: "config",
+		Name:      "last_reload_success_timestamp_seconds",
+		Help:      "Unix time of the last successful config reload.",
+	},
+)
+
+// HTTPRequests 网关处理的请求数，endpoint为归一化后的接口路径，status_class为2xx、4xx等
+var HTTPRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of requests handled by the gateway.",
+	},
+	[]string{"module", "cluster", "endpoint", "method", "status_class", "runmode"},
+)
+
+// HTTPRequestDuration 请求处理耗时，不区分接口以控制序列数
+var HTTPRequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "ihub",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests handled by the gateway.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	},
+	[]string{"module", "cluster", "method", "status_class", "runmode"},
+)
+
+// HTTPInflight 正在处理的请求数
+var HTTPInflight = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "ihub",
+		Subsystem: "http",
+		Name:      "requests_inflight",
+		Help:      "Number of requests currently being handled by the gateway.",
+	},
+	[]string{"module", "cluster", "method", "runmode"},
+)
+
+// 审批判断的结果
+const (
+	ApprovalAllowed = "allowed"
+	ApprovalHeld    = "held"
+	ApprovalDenied  = "denied"
+)
+
+// ApprovalDecisions 审批判断的结果，decision为allowed(直接放行)、held(需要审批)、denied(判断失败被拒绝)
+var ApprovalDecisions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "approve",
+		Name:      "decisions_total",
+		Help:      "Number of approval decisions by result.",
+	},
+	[]string{"module", "decision"},
+)
+
+// DBQueryDuration 数据库查询耗时，function为pkg/db中的函数名
+var DBQueryDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "ihub",
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of database queries by function.",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	},
+	[]string{"function"},
+)
+
+// TracingSpans 导出的span数，result为exported(导出成功)、failed(导出失败)、dropped(队列已满丢弃)
+var TracingSpans = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "tracing",
+		Name:      "spans_total",
+		Help:      "Number of finished spans by export result.",
+	},
+	[]string{"result"},
+)
+
+// AuditRecords 审计日志的记录数，result为recorded或failed(写入失败)
+var AuditRecords = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "ihub",
+		Subsystem: "audit",
+		Name:      "records_total",
+		Help:      "Number of audit log records by result.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(ProxyUpstreamErrors, ProxyRetries, BreakerState, BreakerRejections, RateLimitRejections,
+		ConcurrencyInflight, ConcurrencyLimit, ConcurrencyRejections, SheddingRejections, CacheRequests,
+		MirrorRequests, CanaryRequests, ConfigReloads, ConfigLastReload, HTTPRequests, HTTPRequestDuration,
+		HTTPInflight, ApprovalDecisions, DBQueryDuration, TracingSpans, AuditRecords)
+}
@@ -0,0 +1,42 @@
+package midware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"ihub/pkg/api"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth 管理接口的认证。配置了admin.tokens时请求需要携带Authorization: Bearer <令牌>，
+// 认证通过后管理员名称保存在上下文中，审计日志及全量记录中记录操作的管理员。
+// 没有配置令牌时管理接口只监听本机，不需要认证。
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokens := config.GetConfig().Admin.Tokens
+		if len(tokens) == 0 {
+			c.Next()
+			return
+		}
+		if auth := c.Request.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token := strings.TrimPrefix(auth, "Bearer ")
+			for name, t := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					c.Set(constants.AdminName, name)
+					c.Next()
+					return
+				}
+			}
+		}
+		rp := api.Reply{
+			Code:    1,
+			Message: "unauthorized",
+			Data:    "",
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, rp)
+	}
+}
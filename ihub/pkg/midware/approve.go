@@ -1,6 +1,7 @@
 package midware
 
 import (
+	"context"
 	"fmt"
 	"ihub/pkg/config"
 	"ihub/pkg/constants"
@@ -8,11 +9,11 @@ import (
 	"net/http"
 )
 
-func parseModulenameClusterid(module string, clusterName string) (string, int, error) {
+func parseModulenameClusterid(ctx context.Context, module string, clusterName string) (string, int, error) {
 	// 根据config将module映射为中英文字符串
 	moduleName := config.GetConfig().ApproveMap.OperatorTransMap[module]
 	// 获取集群域名和集群ID
-	nameDomainIdList, err := db.GetDomainIdByClusterName(clusterName)
+	nameDomainIdList, err := db.GetDomainIdByClusterName(ctx, clusterName)
 	if err != nil {
 		return "", 0, err
 	}
@@ -25,11 +26,11 @@ func parseModulenameClusterid(module string, clusterName string) (string, int, e
 }
 
 // 查询默认审批权限
-func defaultAuth(endpoint string, moduleName string, clusterID int) (bool, error) {
+func defaultAuth(ctx context.Context, endpoint string, moduleName string, clusterID int) (bool, error) {
 	// 根据配置文件及endpoint查询operatename
 	operateName := config.GetConfig().ApproveMap.OperatorTransMap[endpoint]
 	// 根据clusterId、Module、operateName查询默认审批权限
-	DefaultauthorityList, err := db.GetDefaultauthority(clusterID, moduleName, operateName)
+	DefaultauthorityList, err := db.GetDefaultauthority(ctx, clusterID, moduleName, operateName)
 	if err != nil {
 		return false, err
 	}
@@ -43,16 +44,16 @@ func defaultAuth(endpoint string, moduleName string, clusterID int) (bool, error
 	}
 }
 
-func ClusterAdminNeedApprove(header http.Header, module string, endpoint string, clusterName string) (bool, error) {
+func ClusterAdminNeedApprove(ctx context.Context, header http.Header, module string, endpoint string, clusterName string) (bool, error) {
 
 	// 解析信息
-	moduleName, clusterID, err := parseModulenameClusterid(module, clusterName)
+	moduleName, clusterID, err := parseModulenameClusterid(ctx, module, clusterName)
 	if err != nil {
 		return false, err
 	}
 
 	// 根据集群ID、模块名、role查询module_id和authority
-	moduleAuthorityModuleIDList, err := db.GetModuleauthorityModuleid(clusterID, moduleName, constants.RoleClusterAdmin)
+	moduleAuthorityModuleIDList, err := db.GetModuleauthorityModuleid(ctx, clusterID, moduleName, constants.RoleClusterAdmin)
 	// , err := db.GetModuleauthorityModuleid(clusterID, moduleName, constants.RoleClusterAdmin)
 	if err != nil {
 		return false, err
@@ -65,7 +66,7 @@ func ClusterAdminNeedApprove(header http.Header, module string, endpoint string,
 		authorityFlags := moduleAuthorityModuleIDList[0].Authority
 		moduleId := moduleAuthorityModuleIDList[0].ModuleId
 		// 根据operateName和moduleId查询operateId
-		operatorIdList, err := db.GetOperatorid(moduleId, moduleName)
+		operatorIdList, err := db.GetOperatorid(ctx, moduleId, moduleName)
 		if err != nil {
 			return false, err
 		}
@@ -79,22 +80,22 @@ func ClusterAdminNeedApprove(header http.Header, module string, endpoint string,
 			return false, fmt.Errorf("operatorIdList item数量不为1")
 		}
 	} else if len(moduleAuthorityModuleIDList) < 1 {
-		return defaultAuth(endpoint, moduleName, clusterID)
+		return defaultAuth(ctx, endpoint, moduleName, clusterID)
 	} else {
 		return false, fmt.Errorf("moduleAuthorityModuleIDList item数量不为1")
 	}
 }
 
 // 组管理员操作 判断是否需要审批
-func GroupAdminNeedApprove(header http.Header, module string, endpoint string, clusterName string, groupId int) (bool, error) {
+func GroupAdminNeedApprove(ctx context.Context, header http.Header, module string, endpoint string, clusterName string, groupId int) (bool, error) {
 	// 解析信息
-	moduleName, clusterID, err := parseModulenameClusterid(module, clusterName)
+	moduleName, clusterID, err := parseModulenameClusterid(ctx, module, clusterName)
 	if err != nil {
 		return false, err
 	}
 
 	// 根据集群ID、模块名、groupId查询module_id和authority
-	moduleAuthorityModuleIDList, err := db.GetModuleauthorityModuleid(clusterID, moduleName, groupId)
+	moduleAuthorityModuleIDList, err := db.GetModuleauthorityModuleid(ctx, clusterID, moduleName, groupId)
 	if err != nil {
 		return false, err
 	}
@@ -106,7 +107,7 @@ func GroupAdminNeedApprove(header http.Header, module string, endpoint string, c
 		authorityFlags := moduleAuthorityModuleIDList[0].Authority
 		moduleId := moduleAuthorityModuleIDList[0].ModuleId
 		// 根据operateName和moduleId查询operateId
-		operatorIdList, err := db.GetOperatorid(moduleId, moduleName)
+		operatorIdList, err := db.GetOperatorid(ctx, moduleId, moduleName)
 		if err != nil {
 			return false, err
 		}
@@ -120,7 +121,7 @@ func GroupAdminNeedApprove(header http.Header, module string, endpoint string, c
 			return false, fmt.Errorf("operatorIdList item数量不为1")
 		}
 	} else if len(moduleAuthorityModuleIDList) < 1 {
-		return defaultAuth(endpoint, moduleName, clusterID)
+		return defaultAuth(ctx, endpoint, moduleName, clusterID)
 	} else {
 		return false, fmt.Errorf("moduleAuthorityModuleIDList item数量不为1")
 	}
@@ -0,0 +1,74 @@
+package midware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"ihub/pkg/audit"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Audit 请求处理完成后将变更请求(GET、HEAD、OPTIONS以外的请求)记录到审计日志，
+// 被中间件拒绝的请求同样记录。审计日志写入失败时只记录错误日志，不影响请求。
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if !audit.Enabled() {
+			return
+		}
+		header := c.Request.Header
+		e := &audit.Entry{
+			Time:          time.Now(),
+			TraceID:       header.Get(constants.HTTPHeaderTraceID),
+			UserID:        c.GetString(constants.VerifiedUserID),
+			GroupID:       c.GetString(constants.VerifiedGroupID),
+			ClientIP:      c.ClientIP(),
+			Method:        c.Request.Method,
+			Path:          c.Request.URL.Path,
+			Cluster:       requestCluster(c),
+			Module:        requestModule(c),
+			Approval:      c.GetString(constants.ApprovalDecision),
+			ApproveID:     c.GetString(constants.ApproveID),
+			Approver:      c.GetString(constants.Approver),
+			Status:        c.Writer.Status(),
+			UpstreamError: c.GetString(constants.UpstreamError),
+		}
+		if e.Module != "" {
+			e.Operate = operateName(c)
+			// 请求头中的身份没有经过校验，单独记录为声称的身份
+			e.ClaimedUserID = header.Get(constants.HTTPHeaderUserID)
+			e.ClaimedGroupID = header.Get(constants.HTTPHeaderGroupID)
+		} else {
+			// 管理接口记录认证的管理员，不使用请求头中的身份
+			e.UserID, e.GroupID = c.GetString(constants.AdminName), ""
+		}
+		if err := audit.Record(e); err != nil {
+			logrus.WithFields(logrus.Fields{"method": e.Method, "path": e.Path, "error": err}).Error("write audit log failed")
+		}
+	}
+}
+
+// operateName 返回请求在operatorTransMap中的操作名称。配置加载后键为小写且不以/开头，
+// 与审批使用的接口不能直接匹配时去掉开头的/后重新转换。
+func operateName(c *gin.Context) string {
+	am := config.GetConfig().ApproveMap
+	endpoint := approveEndpoint(c)
+	if name, ok := am.OperatorTransMap[endpoint]; ok {
+		return name
+	}
+	endpoint = strings.ToLower(strings.TrimPrefix(endpoint, "/"))
+	if trans, ok := am.AppstoreTransMap[endpoint]; ok {
+		endpoint = trans
+	}
+	return am.OperatorTransMap[strings.ToLower(endpoint)]
+}
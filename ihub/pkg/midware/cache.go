@@ -0,0 +1,323 @@
+package midware
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ihub/pkg/cache"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 缓存的身份范围
+const (
+	CacheScopeUser  = "user"
+	CacheScopeGroup = "group"
+)
+
+// 响应头X-Cache的取值
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheRevalidated = "REVALIDATED"
+)
+
+// Cache 缓存配置了cache的路由的GET响应，按集群、模块(灰度路由选择的版本)、路径、查询参数及身份范围区分。
+// 遵循上游的Cache-Control及ETag，同一集群模块的增删改请求成功后删除该模块的缓存。
+func Cache() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		module := requestModule(c)
+		cluster := requestCluster(c)
+		switch c.Request.Method {
+		case http.MethodGet:
+		case http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		default:
+			c.Next()
+			if status := c.Writer.Status(); status >= 200 && status < 300 {
+				// 灰度版本与原模块通常共享数据，两者的缓存都失效
+				cache.Invalidate(cluster, module)
+				if original := c.Param("moudle"); original != module {
+					cache.Invalidate(cluster, original)
+				}
+			}
+			return
+		}
+
+		route := requestRoute(c)
+		reqCC := parseCacheControl(c.Request.Header)
+		if route == nil || route.Cache == nil || isStream(c) || reqCC.has("no-store") {
+			c.Next()
+			return
+		}
+		scope, ok := cacheScope(c, route.Cache.Scope)
+		if !ok {
+			c.Next()
+			return
+		}
+		group := cache.Group(cluster, module)
+		key := cache.Key(cluster, module, c.Param("proxyPath"), c.Request.URL.RawQuery, scope,
+			c.Request.Header.Get("Accept-Encoding"))
+
+		entry, found := cache.Get(group, key)
+		if found && entry.Fresh(time.Now()) && !reqCC.has("no-cache") && reqCC["max-age"] != "0" {
+			metrics.CacheRequests.WithLabelValues(module, "hit").Inc()
+			serveCached(c, entry, cacheHit)
+			c.Abort()
+			return
+		}
+
+		// 过期的响应带ETag时向上游发送条件请求，上游返回304时继续使用缓存
+		revalidate := found && entry.ETag != ""
+		clientETag, hasClientETag := c.Request.Header["If-None-Match"]
+		if revalidate {
+			c.Request.Header.Set("If-None-Match", entry.ETag)
+		}
+		generation := cache.Generation(group)
+		w := &cacheWriter{ResponseWriter: c.Writer, revalidate: revalidate, limit: cache.MaxEntryBytes()}
+		w.Header().Set("X-Cache", cacheMiss)
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if revalidate {
+			if hasClientETag {
+				c.Request.Header["If-None-Match"] = clientETag
+			} else {
+				c.Request.Header.Del("If-None-Match")
+			}
+		}
+
+		now := time.Now()
+		if w.notModified {
+			metrics.CacheRequests.WithLabelValues(module, "revalidated").Inc()
+			refreshed := *entry
+			refreshed.Stored = now
+			refreshed.Expires = now
+			if ttl, ok := cacheTTL(route.Cache, w.header, scope, c.Request.Header); ok {
+				refreshed.Expires = now.Add(ttl)
+			}
+			cache.Set(key, &refreshed, generation)
+			serveCached(c, &refreshed, cacheRevalidated)
+			return
+		}
+		metrics.CacheRequests.WithLabelValues(module, "miss").Inc()
+		if !c.GetBool(constants.UpstreamResponded) || w.status != http.StatusOK || w.overflow {
+			return
+		}
+		ttl, ok := cacheTTL(route.Cache, w.header, scope, c.Request.Header)
+		etag := w.header.Get("ETag")
+		// 不能直接使用的响应(ttl为0)只有带ETag时才有缓存的意义
+		if !ok || (ttl <= 0 && etag == "") {
+			return
+		}
+		// 跨域响应头与请求的来源有关，由CORS中间件按每个请求设置，不缓存
+		header := w.header.Clone()
+		header.Del("X-Cache")
+		for name := range header {
+			if strings.HasPrefix(name, "Access-Control-") {
+				delete(header, name)
+			}
+		}
+		cache.Set(key, &cache.Entry{
+			Group:   group,
+			Status:  w.status,
+			Header:  header,
+			Body:    w.body.Bytes(),
+			ETag:    etag,
+			Stored:  now,
+			Expires: now.Add(ttl),
+		}, generation)
+	}
+}
+
+// cacheScope 返回缓存的身份范围。按用户或组缓存时只使用Auth校验令牌后的身份，
+// 不使用客户端可以伪造的X-User-ID、X-Group-ID，没有校验过的身份时不缓存，请求直接转发到上游
+func cacheScope(c *gin.Context, scope string) (string, bool) {
+	switch scope {
+	case CacheScopeUser:
+		v := c.GetString(constants.VerifiedUserID)
+		return "user:" + v, v != ""
+	case CacheScopeGroup:
+		v := c.GetString(constants.VerifiedGroupID)
+		return "group:" + v, v != ""
+	}
+	return "", true
+}
+
+// identityHeaders 标识请求者身份的请求头，带有这些请求头的请求的响应不保存到所有用户共享的缓存
+var identityHeaders = []string{"Cookie", "X-Auth-Token", constants.HTTPHeaderUserID, constants.HTTPHeaderGroupID}
+
+// cacheTTL 根据上游响应的Cache-Control计算缓存时间，返回false时不缓存。
+// 缓存键只区分Accept-Encoding，Vary中有其他请求头的响应不缓存。
+// 所有用户共享的缓存不保存private响应及带有Cookie、用户等身份请求头的请求的响应，
+// 带Authorization的请求只有public或s-maxage时才保存。
+func cacheTTL(cfg *config.RouteCacheConfig, header http.Header, scope string, reqHeader http.Header) (time.Duration, bool) {
+	cc := parseCacheControl(header)
+	if cc.has("no-store") || header.Get("Set-Cookie") != "" || !varyCacheable(header) {
+		return 0, false
+	}
+	shared := scope == ""
+	if shared && cc.has("private") {
+		return 0, false
+	}
+	if shared && hasIdentity(reqHeader) {
+		return 0, false
+	}
+	if shared && reqHeader.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") {
+		return 0, false
+	}
+	if cc.has("no-cache") {
+		return 0, true
+	}
+	if v, ok := cc["s-maxage"]; ok && shared {
+		return parseSeconds(v)
+	}
+	if v, ok := cc["max-age"]; ok {
+		return parseSeconds(v)
+	}
+	return cfg.TTL, true
+}
+
+// varyCacheable 判断Vary是否只包含缓存键区分的Accept-Encoding
+func varyCacheable(header http.Header) bool {
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func hasIdentity(reqHeader http.Header) bool {
+	for _, name := range identityHeaders {
+		if reqHeader.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSeconds(v string) (time.Duration, bool) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheControl Cache-Control中的指令，没有值的指令对应空字符串
+type cacheControl map[string]string
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
+
+// serveCached 返回缓存的响应，客户端的If-None-Match与ETag相同时返回304
+func serveCached(c *gin.Context, e *cache.Entry, state string) {
+	h := c.Writer.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("X-Cache", state)
+	h.Set("Age", strconv.Itoa(int(time.Since(e.Stored).Seconds())))
+	if e.ETag != "" && etagMatch(c.Request.Header.Get("If-None-Match"), e.ETag) {
+		h.Del("Content-Length")
+		c.Writer.WriteHeader(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Writer.WriteHeader(e.Status)
+	c.Writer.Write(e.Body)
+}
+
+// etagMatch 按弱比较判断If-None-Match是否包含etag
+func etagMatch(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	weak := func(s string) string { return strings.TrimPrefix(strings.TrimSpace(s), "W/") }
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		if weak(v) == weak(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheWriter 转发响应的同时保存响应体，条件请求返回304时不写给客户端，由Cache返回缓存的响应
+type cacheWriter struct {
+	gin.ResponseWriter
+	revalidate  bool
+	notModified bool
+	status      int
+	// header 为写入响应头时的快照
+	header   http.Header
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.header == nil {
+		w.header = w.Header().Clone()
+	}
+	if w.revalidate && code == http.StatusNotModified {
+		w.notModified = true
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.notModified {
+		return len(b), nil
+	}
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if int64(w.body.Len()+len(b)) > w.limit {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
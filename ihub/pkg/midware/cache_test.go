@@ -0,0 +1,45 @@
+package midware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"ihub/pkg/config"
+)
+
+func TestCacheTTL(t *testing.T) {
+	cfg := &config.RouteCacheConfig{TTL: 30 * time.Second}
+	tests := []struct {
+		name      string
+		header    http.Header
+		scope     string
+		reqHeader http.Header
+		want      time.Duration
+		ok        bool
+	}{
+		{"route ttl", http.Header{}, "", http.Header{}, 30 * time.Second, true},
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, "", http.Header{}, time.Minute, true},
+		{"vary accept-encoding", http.Header{"Vary": {"Accept-Encoding"}}, "", http.Header{}, 30 * time.Second, true},
+		{"vary other header", http.Header{"Vary": {"Accept-Encoding, Accept-Language"}}, "", http.Header{}, 0, false},
+		{"vary star", http.Header{"Vary": {"*"}}, "", http.Header{}, 0, false},
+		{"set-cookie", http.Header{"Set-Cookie": {"sid=1"}}, "", http.Header{}, 0, false},
+		{"shared private", http.Header{"Cache-Control": {"private"}}, "", http.Header{}, 0, false},
+		{"shared cookie", http.Header{}, "", http.Header{"Cookie": {"sid=1"}}, 0, false},
+		{"shared auth token", http.Header{}, "", http.Header{"X-Auth-Token": {"t"}}, 0, false},
+		{"shared user", http.Header{}, "", http.Header{"X-User-Id": {"1001"}}, 0, false},
+		{"shared group", http.Header{}, "", http.Header{"X-Group-Id": {"7"}}, 0, false},
+		{"shared cookie public", http.Header{"Cache-Control": {"public"}}, "", http.Header{"Cookie": {"sid=1"}}, 0, false},
+		{"shared authorization", http.Header{}, "", http.Header{"Authorization": {"Bearer t"}}, 0, false},
+		{"shared authorization public", http.Header{"Cache-Control": {"public"}}, "", http.Header{"Authorization": {"Bearer t"}}, 30 * time.Second, true},
+		{"user scope cookie", http.Header{}, "user:1001", http.Header{"Cookie": {"sid=1"}}, 30 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cacheTTL(cfg, tt.header, tt.scope, tt.reqHeader)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("cacheTTL() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
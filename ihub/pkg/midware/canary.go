@@ -0,0 +1,138 @@
+package midware
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 灰度路由的粘性分配方式
+const (
+	CanaryStickyUser   = "user"
+	CanaryStickyCookie = "cookie"
+)
+
+// Canary 按灰度路由配置选择模块版本并保存在上下文中，在配置的中间件之前执行，
+// 响应缓存、限流、审计日志、监控指标及代理都按选择的版本处理请求
+func Canary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		module := c.Param("moudle")
+		if module == "" {
+			return
+		}
+		if version := canaryModule(c, module); version != module {
+			c.Set(constants.ModuleVersion, version)
+		}
+	}
+}
+
+// requestModule 返回请求实际访问的模块，灰度路由选择了其他版本时为该版本
+func requestModule(c *gin.Context) string {
+	if version := c.GetString(constants.ModuleVersion); version != "" {
+		return version
+	}
+	return c.Param("moudle")
+}
+
+// requestRoute 返回请求的路由配置，灰度版本没有单独的路由配置时使用原模块的路由配置
+func requestRoute(c *gin.Context) *config.RouteConfig {
+	cfg := config.GetConfig()
+	module := requestModule(c)
+	route := cfg.MatchRoute(module, c.Param("proxyPath"))
+	if route == nil && module != c.Param("moudle") {
+		route = cfg.MatchRoute(c.Param("moudle"), c.Param("proxyPath"))
+	}
+	return route
+}
+
+// canaryModule 按灰度路由配置选择模块版本，没有配置时返回原模块。
+// 请求头、Cookie或用户指定的版本优先，其次按粘性分配，最后按权重随机分配。
+// 用户只使用校验过的身份，请求头中的X-User-ID可以由客户端伪造
+func canaryModule(c *gin.Context, module string) string {
+	cfg := config.GetConfig().MatchCanary(module)
+	if cfg == nil {
+		return module
+	}
+	total := 0
+	for _, v := range cfg.Versions {
+		if v.Weight > 0 {
+			total += v.Weight
+		}
+	}
+	if total == 0 {
+		return module
+	}
+
+	version, reason := canaryOverride(c, cfg), "override"
+	if version == "" {
+		n := rand.Intn(total)
+		reason = "weight"
+		if uid := c.GetString(constants.VerifiedUserID); cfg.Sticky == CanaryStickyUser && uid != "" {
+			// 同一用户的哈希值不变，权重不变时总是分配到同一版本，多个ihub实例的结果也相同
+			h := fnv.New32a()
+			h.Write([]byte(module + ":" + uid))
+			n = int(h.Sum32() % uint32(total))
+			reason = "sticky"
+		}
+		for _, v := range cfg.Versions {
+			if v.Weight <= 0 {
+				continue
+			}
+			if n < v.Weight {
+				version = v.Module
+				break
+			}
+			n -= v.Weight
+		}
+		if cfg.Sticky == CanaryStickyCookie {
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     canaryCookie(cfg),
+				Value:    version,
+				Path:     "/" + module,
+				HttpOnly: true,
+			})
+		}
+	}
+	metrics.CanaryRequests.WithLabelValues(module, version, reason).Inc()
+	c.Header(constants.HTTPHeaderCanary, version)
+	return version
+}
+
+// canaryOverride 返回请求头、Cookie或用户指定的版本，只接受配置中存在的版本
+func canaryOverride(c *gin.Context, cfg *config.CanaryConfig) string {
+	var candidates []string
+	if cfg.Header != "" {
+		candidates = append(candidates, c.Request.Header.Get(cfg.Header))
+	}
+	if cfg.Cookie != "" || cfg.Sticky == CanaryStickyCookie {
+		if v, err := c.Cookie(canaryCookie(cfg)); err == nil {
+			candidates = append(candidates, v)
+		}
+	}
+	if uid := c.GetString(constants.VerifiedUserID); uid != "" {
+		// 配置文件中的key会被转换为小写
+		candidates = append(candidates, cfg.Users[strings.ToLower(uid)])
+	}
+	for _, candidate := range candidates {
+		for _, v := range cfg.Versions {
+			if candidate != "" && candidate == v.Module {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+func canaryCookie(cfg *config.CanaryConfig) string {
+	if cfg.Cookie != "" {
+		return cfg.Cookie
+	}
+	return constants.DefaultCanaryCookie
+}
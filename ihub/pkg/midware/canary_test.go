@@ -0,0 +1,69 @@
+package midware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ihub/pkg/config/configtest"
+	"ihub/pkg/constants"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestCanaryBeforeMidwares 灰度路由选择的版本对之后的中间件可见
+func TestCanaryBeforeMidwares(t *testing.T) {
+	configtest.Init(t, `
+routes:
+- module: "appstore"
+  path: "/v1"
+  maxBodyBytes: 1024
+canaries:
+- module: "appstore"
+  versions:
+  - module: "appstore"
+    weight: 0
+  - module: "appstore-v2"
+    weight: 100
+  header: "X-Canary"
+`)
+	gin.SetMode(gin.TestMode)
+	var module string
+	var maxBodyBytes int64
+	r := gin.New()
+	r.Use(Canary())
+	r.Any("/:moudle/*proxyPath", func(c *gin.Context) {
+		module = requestModule(c)
+		// 灰度版本没有单独的路由配置，使用原模块的路由配置
+		if route := requestRoute(c); route != nil {
+			maxBodyBytes = route.MaxBodyBytes
+		}
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"weight", "", "appstore-v2"},
+		{"header override", "appstore", "appstore"},
+		{"unknown version ignored", "appstore-v3", "appstore-v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module, maxBodyBytes = "", 0
+			req := httptest.NewRequest(http.MethodGet, "/appstore/v1/store/list", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Canary", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if module != tt.want || rec.Header().Get(constants.HTTPHeaderCanary) != tt.want {
+				t.Errorf("module = %q, X-Ihub-Canary = %q, want %q", module, rec.Header().Get(constants.HTTPHeaderCanary), tt.want)
+			}
+			if maxBodyBytes != 1024 {
+				t.Errorf("maxBodyBytes = %d, want the appstore route", maxBodyBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package midware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+)
+
+// binaryContentTypes 不记录内容的请求/响应类型(前缀匹配)
+var binaryContentTypes = []string{
+	"multipart/",
+	"application/octet-stream",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-tar",
+	"application/x-compressed-tar",
+	"image/",
+	"audio/",
+	"video/",
+}
+
+// isBinaryContentType 判断Content-Type是否为二进制或文件上传，包括log.skipContentTypes中配置的类型
+func isBinaryContentType(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	mediaType = strings.ToLower(mediaType)
+	for _, prefix := range append(binaryContentTypes, config.GetConfig().LOG.SkipContentTypes...) {
+		if strings.HasPrefix(mediaType, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// captureLimit 请求/响应体记录的最大字节数
+func captureLimit() int {
+	if limit := config.GetConfig().LOG.MaxCaptureBytes; limit > 0 {
+		return limit
+	}
+	return constants.DefaultMaxCaptureBytes
+}
+
+// boundedBuffer 只保存前limit字节的缓冲区，超出的部分只计数
+type boundedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+	total int64
+	// omitted 不为空时不记录内容，String返回该说明
+	omitted string
+}
+
+func newBoundedBuffer(limit int) *boundedBuffer {
+	return &boundedBuffer{limit: limit}
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if b.omitted == "" {
+		if room := b.limit - b.buf.Len(); room > 0 {
+			if len(p) > room {
+				b.buf.Write(p[:room])
+			} else {
+				b.buf.Write(p)
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// omit 不再记录内容，如二进制、文件上传或流式响应
+func (b *boundedBuffer) omit(reason string) {
+	b.omitted = reason
+	b.buf.Reset()
+}
+
+func (b *boundedBuffer) String() string {
+	if b.omitted != "" {
+		return fmt.Sprintf("[%s omitted, %d bytes]", b.omitted, b.total)
+	}
+	if b.total > int64(b.buf.Len()) {
+		return fmt.Sprintf("%s...[truncated, %d bytes total]", b.buf.String(), b.total)
+	}
+	return b.buf.String()
+}
+
+// captureReader 在请求体被代理读取的同时记录前若干字节，不会提前读取整个请求体
+type captureReader struct {
+	io.ReadCloser
+	buf *boundedBuffer
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.buf.Write(p[:n])
+	}
+	return n, err
+}
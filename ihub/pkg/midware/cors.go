@@ -0,0 +1,103 @@
+package midware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultCORSMethods 未配置AllowMethods时允许的请求方法
+var defaultCORSMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// CORS 在网关统一处理跨域请求，预检请求直接返回，不再转发到各模块
+func CORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.Request.Header.Get("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		cfg := config.GetConfig().CORS
+		if route := config.GetConfig().MatchRoute(c.Param("moudle"), c.Param("proxyPath")); route != nil && route.CORS != nil {
+			cfg = *route.CORS
+		}
+		c.Set(constants.CORSHandled, true)
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+
+		allowed, wildcard := corsOriginAllowed(cfg.AllowOrigins, origin)
+		if !allowed {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+		// 允许携带凭证时不能使用*
+		if wildcard && !cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			methods := cfg.AllowMethods
+			if len(methods) == 0 {
+				methods = defaultCORSMethods
+			}
+			h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(cfg.AllowHeaders) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowHeaders, ", "))
+			} else if reqHeaders := c.Request.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", reqHeaders)
+				h.Add("Vary", "Access-Control-Request-Headers")
+			}
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if len(cfg.ExposeHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposeHeaders, ", "))
+		}
+		c.Next()
+	}
+}
+
+// corsOriginAllowed 判断来源是否允许跨域访问，第二个返回值表示是否由*匹配
+func corsOriginAllowed(origins []string, origin string) (bool, bool) {
+	for _, o := range origins {
+		switch {
+		case o == "*":
+			return true, true
+		case strings.HasPrefix(o, "*."):
+			// *.example.com匹配https://a.example.com，不匹配example.com
+			host := origin
+			if i := strings.Index(host, "://"); i >= 0 {
+				host = host[i+3:]
+			}
+			if i := strings.LastIndex(host, ":"); i >= 0 {
+				host = host[:i]
+			}
+			if strings.HasSuffix(strings.ToLower(host), strings.ToLower(o[1:])) {
+				return true, false
+			}
+		case strings.EqualFold(o, origin):
+			return true, false
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,226 @@
+package midware
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+)
+
+// Factory 根据配置文件midwares中的配置块构造中间件，配置块有误时返回错误
+type Factory func(cfg config.MidwareConfig) (gin.HandlerFunc, error)
+
+// registry 中间件名称及对应的构造函数，重建中间件链时只构造配置中使用的中间件。
+// 槽位数按注册的中间件数确定，InitMidwares构造槽位后不能再注册
+var registry = struct {
+	mu        sync.Mutex
+	names     []string
+	factories map[string]Factory
+	sealed    bool
+}{
+	names: []string{
+		constants.MidwareLog, constants.MidwareTrace, constants.MidwareInOut, constants.MidwareAuth,
+		constants.MidwareApprove, constants.MidwareRateLimit, constants.MidwareCache, constants.MidwareCORS,
+	},
+	factories: map[string]Factory{
+		constants.MidwareLog:       newGinLogger,
+		constants.MidwareTrace:     noOptions(Trace),
+		constants.MidwareInOut:     noOptions(InOut),
+		constants.MidwareAuth:      noOptions(Auth),
+		constants.MidwareApprove:   noOptions(Approve),
+		constants.MidwareRateLimit: noOptions(RateLimit),
+		constants.MidwareCache:     noOptions(Cache),
+		constants.MidwareCORS:      noOptions(CORS),
+	},
+}
+
+func init() {
+	config.SetMidwareNames(Names)
+}
+
+// Register 注册新的中间件，注册后可以在配置文件midwares中使用。
+// 需要在InitMidwares之前调用，之后注册的中间件没有槽位，调用时panic
+func Register(name string, f Factory) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.sealed {
+		panic(fmt.Sprintf("midware %q registered after InitMidwares", name))
+	}
+	if _, ok := registry.factories[name]; !ok {
+		registry.names = append(registry.names, name)
+	}
+	registry.factories[name] = f
+}
+
+// Names 返回已注册的中间件名称，用于检查配置文件
+func Names() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return append([]string{}, registry.names...)
+}
+
+func factory(name string) (Factory, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	f, ok := registry.factories[name]
+	return f, ok
+}
+
+// noOptions 用于没有配置项的中间件，配置块不为空时报错
+func noOptions(f func() gin.HandlerFunc) Factory {
+	return func(cfg config.MidwareConfig) (gin.HandlerFunc, error) {
+		if len(cfg.Config) > 0 {
+			return nil, fmt.Errorf("midware %q does not accept config", cfg.Midware)
+		}
+		return f(), nil
+	}
+}
+
+// decodeOptions 将配置块解析到中间件的配置结构中，未知的配置项报错
+func decodeOptions(cfg config.MidwareConfig, out interface{}) error {
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	return dec.Decode(cfg.Config)
+}
+
+// chain 一个版本的中间件链，请求开始时取当前版本，整个请求都使用该版本
+type chain struct {
+	handlers []gin.HandlerFunc
+	configs  []config.MidwareConfig
+}
+
+// dispatcher 中间件调度器，持有可以整体替换的中间件链。
+// gin.Engine中注册固定数量的槽位，第i个槽位执行当前中间件链中的第i个中间件，
+// 中间件中调用c.Next()时进入下一个槽位，没有中间件的槽位直接跳过。
+type dispatcher struct {
+	current atomic.Pointer[chain]
+}
+
+var chains dispatcher
+
+// slots 返回注册到gin.Engine中的槽位，槽位数为已注册的中间件数，每个中间件在链中最多出现一次。
+// 构造槽位后不能再注册中间件
+func (d *dispatcher) slots() []gin.HandlerFunc {
+	registry.mu.Lock()
+	registry.sealed = true
+	n := len(registry.names)
+	registry.mu.Unlock()
+	slots := make([]gin.HandlerFunc, n)
+	for i := range slots {
+		i := i
+		slots[i] = func(c *gin.Context) {
+			ch := d.requestChain(c)
+			if i >= len(ch.handlers) || !applies(c, ch.configs[i]) {
+				return
+			}
+			traced(c, ch.configs[i].Midware, ch.handlers[i])
+		}
+	}
+	return slots
+}
+
+// traced 请求开启了追踪时为中间件创建span，中间件中c.Next()之后执行的中间件及代理是其子span
+func traced(c *gin.Context, name string, h gin.HandlerFunc) {
+	parent := c.Request.Context()
+	ctx, span := tracing.StartSpan(parent, "midware "+name, tracing.KindInternal)
+	if span == nil {
+		h(c)
+		return
+	}
+	c.Request = c.Request.WithContext(ctx)
+	h(c)
+	span.End()
+	// 中间件没有调用c.Next()时，之后的中间件仍以外层的span为父span
+	c.Request = c.Request.WithContext(parent)
+}
+
+// requestChain 返回请求使用的中间件链，第一个槽位取当前版本并保存在请求上下文中
+func (d *dispatcher) requestChain(c *gin.Context) *chain {
+	if v, ok := c.Get(constants.MidwareChain); ok {
+		return v.(*chain)
+	}
+	ch := d.current.Load()
+	c.Set(constants.MidwareChain, ch)
+	return ch
+}
+
+// load 按配置构造新的中间件链并替换当前的中间件链，构造失败时保持原中间件链
+func (d *dispatcher) load(cfgs []config.MidwareConfig) error {
+	ch := &chain{configs: cfgs}
+	for i, cfg := range cfgs {
+		f, ok := factory(cfg.Midware)
+		if !ok {
+			return fmt.Errorf("%s:%s", cfg.Midware, "not exist.")
+		}
+		h, err := f(cfg)
+		if err != nil {
+			return fmt.Errorf("midwares[%d]: %w", i, err)
+		}
+		ch.handlers = append(ch.handlers, h)
+	}
+	d.current.Store(ch)
+	return nil
+}
+
+// applies 判断中间件是否作用于当前请求，modules为空时作用于所有模块
+func applies(c *gin.Context, cfg config.MidwareConfig) bool {
+	if len(cfg.Modules) > 0 {
+		module := c.Param("moudle")
+		found := false
+		for _, m := range cfg.Modules {
+			if m == module {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, p := range cfg.SkipPaths {
+		if strings.HasPrefix(c.Param("proxyPath"), p) {
+			return false
+		}
+	}
+	return true
+}
+
+// InitMidwares 在gin.Engine中注册中间件槽位并按配置构造中间件链，
+// 配置热加载后midwares有变化时重建中间件链，重建失败时配置回滚
+func InitMidwares(r *gin.Engine) error {
+	if err := chains.load(config.GetConfig().Midwares); err != nil {
+		return err
+	}
+	r.Use(chains.slots()...)
+	config.OnReload(func(ev config.ReloadEvent) error {
+		if ev.Old != nil && reflect.DeepEqual(ev.Old.Midwares, ev.New.Midwares) {
+			return nil
+		}
+		if err := chains.load(ev.New.Midwares); err != nil {
+			return err
+		}
+		names := make([]string, len(ev.New.Midwares))
+		for i, mw := range ev.New.Midwares {
+			names[i] = mw.Midware
+		}
+		logrus.WithFields(logrus.Fields{"version": ev.Version, "midwares": names}).Info("midware chain rebuilt")
+		return nil
+	})
+	return nil
+}
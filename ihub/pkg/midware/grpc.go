@@ -0,0 +1,48 @@
+package midware
+
+import (
+	"strings"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/grpcweb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GRPC 将gRPC及gRPC-Web请求的服务名解析为模块名称，
+// 使后续的中间件和代理可以像普通请求一样按模块、操作名称处理。
+// gRPC请求的路径为/package.Service/Method，替换后moudle为模块名称，proxyPath为完整的gRPC路径。
+func GRPC() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !grpcweb.IsGRPC(c.Request) && !grpcweb.IsGRPCWeb(c.Request) {
+			return
+		}
+		service, method, ok := grpcweb.SplitMethod(c.Request.URL.Path)
+		if !ok {
+			return
+		}
+		route := config.GetConfig().MatchService(service)
+		if route == nil {
+			return
+		}
+		setParam(c, "moudle", route.Module)
+		setParam(c, "proxyPath", c.Request.URL.Path)
+		c.Set(constants.GRPCMethod, c.Request.URL.Path)
+		// 配置文件中的key会被转换为小写
+		if operate, ok := route.Operates[strings.ToLower(method)]; ok {
+			c.Set(constants.OperateName, operate)
+		}
+	}
+}
+
+// setParam 替换路由参数
+func setParam(c *gin.Context, key string, value string) {
+	for i := range c.Params {
+		if c.Params[i].Key == key {
+			c.Params[i].Value = value
+			return
+		}
+	}
+	c.Params = append(c.Params, gin.Param{Key: key, Value: value})
+}
@@ -0,0 +1,85 @@
+package midware
+
+import (
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics 记录请求数、耗时及处理中的请求数，在过载保护之前执行，被拒绝的请求也会记录。
+// 集群、模块及接口标签按配置限制取值个数。
+func Metrics() gin.HandlerFunc {
+	setMetricLimits(config.GetConfig())
+	config.OnReload(func(ev config.ReloadEvent) error {
+		setMetricLimits(ev.New)
+		return nil
+	})
+	return func(c *gin.Context) {
+		// 只记录代理的请求，/health、/metrics等网关自身的接口不记录
+		module := c.Param("moudle")
+		if module == "" {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		runmode := config.GetConfig().Runmode
+		method := metrics.Method(c.Request.Method)
+		// 处理中的请求数在选择灰度版本之前记录，按原模块计数
+		inflight := metrics.HTTPInflight.WithLabelValues(metrics.Module(module), metrics.Cluster(requestCluster(c)), method, runmode)
+		inflight.Inc()
+		defer inflight.Dec()
+
+		c.Next()
+
+		// InOut解析集群名称后使用解析的结果，灰度路由选择版本后按版本记录
+		cluster := metrics.Cluster(requestCluster(c))
+		moduleLabel := metrics.Module(requestModule(c))
+		class := metrics.StatusClass(c.Writer.Status())
+		endpoint := metrics.Endpoint(moduleLabel, c.Param("proxyPath"))
+		metrics.HTTPRequests.WithLabelValues(moduleLabel, cluster, endpoint, method, class, runmode).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(moduleLabel, cluster, method, class, runmode).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// setMetricLimits 配置中出现的模块总是单独记录，其他模块按出现顺序最多记录maxModules个
+func setMetricLimits(cfg *config.Configuration) {
+	m := cfg.Metrics
+	l := metrics.Limits{
+		Clusters:      m.Clusters,
+		MaxClusters:   orDefault(m.MaxClusters, constants.DefaultMetricsMaxClusters),
+		MaxModules:    orDefault(m.MaxModules, constants.DefaultMetricsMaxModules),
+		MaxEndpoints:  orDefault(m.MaxEndpoints, constants.DefaultMetricsMaxEndpoints),
+		EndpointDepth: orDefault(m.EndpointDepth, constants.DefaultMetricsEndpointDepth),
+	}
+	for _, r := range cfg.Routes {
+		l.Modules = append(l.Modules, r.Module)
+	}
+	for _, cn := range cfg.Canaries {
+		l.Modules = append(l.Modules, cn.Module)
+		for _, v := range cn.Versions {
+			l.Modules = append(l.Modules, v.Module)
+		}
+	}
+	for _, h := range cfg.Health.Modules {
+		l.Modules = append(l.Modules, h.Module)
+	}
+	for module := range cfg.ApproveMap.ModuleTransMap {
+		l.Modules = append(l.Modules, module)
+	}
+	for module := range cfg.ApproveMap.OuterServicePortMap {
+		l.Modules = append(l.Modules, module)
+	}
+	metrics.SetLimits(l)
+}
+
+func orDefault(v int, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
@@ -1,118 +1,193 @@
 package midware
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"os"
 	"strconv"
 	"time"
 
 	"ihub/pkg/api"
+	"ihub/pkg/balancer"
 	"ihub/pkg/config"
 	"ihub/pkg/constants"
 	"ihub/pkg/db"
+	"ihub/pkg/health"
+	"ihub/pkg/logging"
+	"ihub/pkg/metrics"
+	"ihub/pkg/tracing"
+	"ihub/pkg/utils"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
-func InitMidwares(r *gin.Engine) error {
-	midwareMap := map[string]gin.HandlerFunc{
-		"log":     GinLogger(),
-		"trace":   Trace(),
-		"inout":   InOut(),
-		"auth":    Auth(),
-		"approve": Approve(),
-	}
-	for _, mw := range config.GetConfig().Midwares {
-		if f, ok := midwareMap[mw.Midware]; ok {
-			r.Use(f)
-		} else {
-			return fmt.Errorf("%s:%s", mw.Midware, "not exist.")
-		}
-	}
-	return nil
-}
-
 // BodyWriter ..
 type BodyWriter struct {
 	gin.ResponseWriter
-	bodyBuf *bytes.Buffer
+	// bodyBuf 只记录响应体的前若干字节
+	bodyBuf *boundedBuffer
+	// skip 为true时不记录响应体，用于WebSocket、SSE等长连接及二进制响应
+	skip bool
 }
 
 func (w *BodyWriter) Write(b []byte) (int, error) {
+	if !w.skip {
+		if utils.IsStreamResponse(w.Header()) {
+			w.skip = true
+			w.bodyBuf.omit("stream")
+		} else if isBinaryContentType(w.Header().Get("Content-Type")) {
+			w.skip = true
+			w.bodyBuf.omit("binary")
+		}
+	}
 	w.bodyBuf.Write(b)
 	return w.ResponseWriter.Write(b)
 }
 
+func (w *BodyWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// isStream 判断请求是否需要按长连接处理
+func isStream(c *gin.Context) bool {
+	if utils.IsStreamRequest(c.Request) {
+		return true
+	}
+	route := config.GetConfig().MatchRoute(c.Param("moudle"), c.Param("proxyPath"))
+	return route != nil && route.Stream
+}
+
+// logOptions log中间件的配置块
+type logOptions struct {
+	// Level 访问日志的级别，为空时使用log.level
+	Level string `mapstructure:"level"`
+}
+
+func newGinLogger(cfg config.MidwareConfig) (gin.HandlerFunc, error) {
+	var opts logOptions
+	if err := decodeOptions(cfg, &opts); err != nil {
+		return nil, fmt.Errorf("midware %q: %w", cfg.Midware, err)
+	}
+	if opts.Level != "" {
+		if _, err := logrus.ParseLevel(opts.Level); err != nil {
+			return nil, fmt.Errorf("midware %q: %w", cfg.Midware, err)
+		}
+	}
+	return ginLogger(opts), nil
+}
+
 /*
 GinLogger is created for ginlog. It put the msg to stdout and logs.
 */
 func GinLogger() gin.HandlerFunc {
-	logger := logrus.New()
-	f, _ := os.OpenFile(constants.DefaultLogName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0755)
-	logger.SetOutput(io.MultiWriter(os.Stdout, f))
-	logger.SetNoLock()
+	return ginLogger(logOptions{})
+}
 
-	logger.SetFormatter(&logrus.TextFormatter{
-		TimestampFormat: "2006-01-02 15:04:05",
-	})
+func ginLogger(opts logOptions) gin.HandlerFunc {
+	// 日志文件、格式及轮转由logging统一设置，中间件链重建时继续使用同一个日志
+	logger := logging.Access()
 
 	return func(c *gin.Context) {
-		level, err := logrus.ParseLevel(config.GetConfig().LOG.Level)
-		if err != nil {
+		// 级别按模块确定，Trace级别时才记录请求/响应头及请求/响应体，通过管理接口开启全量记录的用户或追踪ID按Trace级别记录
+		traceID := c.Request.Header.Get(constants.HTTPHeaderTraceID)
+		captured := logging.Captured(c.Request.Header.Get(constants.HTTPHeaderUserID), traceID)
+		level := logging.AccessLevel(c.Param("moudle"), opts.Level)
+		if captured {
 			level = logrus.TraceLevel
 		}
-		logger.SetLevel(level)
+		capture := level >= logrus.TraceLevel
+		// 累加之后的中间件及代理访问数据库、转发上游的耗时
+		ctx, timings := logging.WithTimings(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
 		startTime := time.Now()
 		reqMethod := c.Request.Method
-		reqBody, _ := ioutil.ReadAll(c.Request.Body)
 		reqURI := c.Request.RequestURI
-		c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+		// 请求体在转发的同时记录前若干字节，不提前读取，避免大文件上传占用内存
+		// 长连接、二进制及文件上传请求不记录请求体
+		stream := isStream(c)
+		limit := captureLimit()
+		reqBody := newBoundedBuffer(limit)
+		if stream {
+			reqBody.omit("stream")
+		} else if capture {
+			if isBinaryContentType(c.Request.Header.Get("Content-Type")) {
+				reqBody.omit("binary")
+			}
+			if c.Request.Body != nil {
+				c.Request.Body = &captureReader{ReadCloser: c.Request.Body, buf: reqBody}
+			}
+		}
 		clientIP := c.ClientIP()
-		traceID := c.Request.Header.Get(constants.HTTPHeaderTraceID)
 
-		bw := &BodyWriter{
-			bodyBuf:        bytes.NewBufferString(""),
-			ResponseWriter: c.Writer,
+		var bw *BodyWriter
+		if capture {
+			bw = &BodyWriter{
+				bodyBuf:        newBoundedBuffer(limit),
+				ResponseWriter: c.Writer,
+				skip:           stream,
+			}
+			if stream {
+				bw.bodyBuf.omit("stream")
+			}
+			c.Writer = bw
 		}
-		c.Writer = bw
 		c.Next()
 		endTime := time.Now()
 		latencyTime := endTime.Sub(startTime)
 		statusCode := c.Writer.Status()
 
-		logger.WithFields(logrus.Fields{
+		entry := logger.WithFields(logrus.Fields{
 			"status_code":  statusCode,
 			"trace_id":     traceID,
 			"latency_time": latencyTime,
 			"client_ip":    clientIP,
 			"req_method":   reqMethod,
 			"req_uri":      reqURI,
-		}).Info()
+			"module":       c.Param("moudle"),
+			"stream":       stream,
+		})
+		// 慢请求按WARN记录耗时的组成，不受采样影响；长连接的耗时为连接时长，不记录
+		threshold := slowThreshold(c)
+		slow := !stream && threshold > 0 && latencyTime >= threshold
+		sampled := captured || slow || logging.Sampled(c.Param("moudle"), reqMethod, statusCode)
+		if slow && level >= logrus.WarnLevel {
+			entry.WithFields(timings.Breakdown(latencyTime)).WithField("slow_threshold", threshold).Warn("slow request")
+		} else if sampled && level >= logrus.InfoLevel {
+			entry.Info()
+		}
+		if !capture || !sampled {
+			return
+		}
 
+		// 请求/响应头中的认证信息及请求/响应体中的密码等字段隐藏后再记录
 		logger.WithFields(logrus.Fields{
 			"Type":      "Request",
 			"ReqUri":    reqURI,
-			"ReqHeader": c.Request.Header,
-			"ReqBody":   string(reqBody),
+			"ReqHeader": logging.RedactHeader(c.Request.Header),
+			"ReqBody":   logging.RedactBody(reqBody.String()),
 		}).Trace()
 
 		logger.WithFields(logrus.Fields{
 			"Type":      "Response",
 			"Status":    statusCode,
-			"resHeader": c.Writer.Header(),
-			"ResBody":   bw.bodyBuf.String(),
+			"resHeader": logging.RedactHeader(c.Writer.Header()),
+			"ResBody":   logging.RedactBody(bw.bodyBuf.String()),
 		}).Trace()
 	}
 }
 
-// Auth
+// slowThreshold 返回请求的慢请求阈值，路由中的配置优先
+func slowThreshold(c *gin.Context) time.Duration {
+	cfg := config.GetConfig()
+	if route := cfg.MatchRoute(c.Param("moudle"), c.Param("proxyPath")); route != nil && route.SlowThreshold > 0 {
+		return route.SlowThreshold
+	}
+	return cfg.LOG.SlowThreshold
+}
+
+// Auth 尚未校验令牌。校验通过后需要设置VerifiedUserID、VerifiedGroupID，按用户或组的响应缓存依赖该身份
 func Auth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -157,7 +232,9 @@ func Approve() gin.HandlerFunc {
 				Message: "目的地获取失败",
 				Data:    "",
 			}
+			approvalDecision(c, metrics.ApprovalDenied)
 			c.AbortWithStatusJSON(http.StatusOK, rp)
+			return
 		} else if destination == constants.DestinationOut &&
 			runmode == constants.RunmodeIn { // 集群外流量到达集群内网关(异常情况)
 			rp := api.Reply{
@@ -165,7 +242,9 @@ func Approve() gin.HandlerFunc {
 				Message: "集群外流量不应到达集群内网关",
 				Data:    "",
 			}
+			approvalDecision(c, metrics.ApprovalDenied)
 			c.AbortWithStatusJSON(http.StatusOK, rp)
+			return
 		}
 
 		// [scheme:][//[userinfo@]host][/]path[?query][#fragment]
@@ -173,17 +252,15 @@ func Approve() gin.HandlerFunc {
 		module := c.Param("moudle")
 
 		// endpoint := utils.FormatEndpoint(c.Param("proxyPath"))
-		endpoint := c.Param("proxyPath")
-		// 如果是应用商店接口，需要进行接口转换，如去掉v1/helm、v1/store等
-		if _, ok := config.GetConfig().ApproveMap.AppstoreTransMap[endpoint]; ok {
-			endpoint = config.GetConfig().ApproveMap.AppstoreTransMap[endpoint]
-		}
+		endpoint := approveEndpoint(c)
 
 		// 判断该模块/操作是否可能审批，若可能审批则返回需要审批的角色
 		inList, role := inCheckList(module, endpoint)
 		// 如果不在列表，则直接通过
 		if !inList {
+			approvalDecision(c, metrics.ApprovalAllowed)
 			c.Next()
+			return
 		}
 
 		// 获取集群名、集群域名
@@ -194,24 +271,30 @@ func Approve() gin.HandlerFunc {
 				Message: "集群名获取失败",
 				Data:    "",
 			}
+			approvalDecision(c, metrics.ApprovalDenied)
 			c.AbortWithStatusJSON(http.StatusOK, rp)
+			return
 		}
 		// 集群内流量到达集群外网关(Next交给Proxy处理)
 		// 集群内(外)流量到达集群内(外)网关(需要审批?Insert:Next)
 		if destination == constants.DestinationIn &&
 			runmode == constants.RunmodeOut { // 集群内流量到达集群外网关(Next交给Proxy处理)
+			approvalDecision(c, metrics.ApprovalAllowed)
 			c.Next()
 		} else {
 			if role == constants.RoleClusterAdmin { // 如果为管理员
-				needApprove, err := ClusterAdminNeedApprove(c.Request.Header, module, endpoint, clusterName.(string))
+				needApprove, err := ClusterAdminNeedApprove(c.Request.Context(), c.Request.Header, module, endpoint, clusterName.(string))
 				if err != nil {
 					rp := api.Reply{
 						Code:    999,
 						Message: err.Error(),
 						Data:    "",
 					}
+					approvalDecision(c, metrics.ApprovalDenied)
 					c.AbortWithStatusJSON(http.StatusOK, rp)
+					return
 				}
+				approvalDecision(c, approvalResult(needApprove))
 				c.Set(constants.NeedApprove, needApprove)
 				c.Next()
 			} else if role == constants.RoleGroupAdmin { // 如果为组管理员
@@ -223,27 +306,61 @@ func Approve() gin.HandlerFunc {
 						Message: "组Id获取失败",
 						Data:    "",
 					}
+					approvalDecision(c, metrics.ApprovalDenied)
 					c.AbortWithStatusJSON(http.StatusOK, rp)
+					return
 				}
-				needApprove, err := GroupAdminNeedApprove(c.Request.Header, module, endpoint, clusterName.(string), groupId.(int))
+				needApprove, err := GroupAdminNeedApprove(c.Request.Context(), c.Request.Header, module, endpoint, clusterName.(string), groupId.(int))
 				if err != nil {
 					rp := api.Reply{
 						Code:    999,
 						Message: err.Error(),
 						Data:    "",
 					}
+					approvalDecision(c, metrics.ApprovalDenied)
 					c.AbortWithStatusJSON(http.StatusOK, rp)
+					return
 				}
+				approvalDecision(c, approvalResult(needApprove))
 				c.Set(constants.NeedApprove, needApprove)
 				c.Next()
+			} else {
+				approvalDecision(c, metrics.ApprovalAllowed)
 			}
 		}
 	}
 }
 
-// 当域名数量为1时，判断集群异常状态
-func checkClusterStatus(clusterName string) error {
-	clusterStatus, err := db.GetClusterStatus(clusterName)
+// approveEndpoint 返回审批映射中使用的接口
+func approveEndpoint(c *gin.Context) string {
+	endpoint := c.Param("proxyPath")
+	// 如果是应用商店接口，需要进行接口转换，如去掉v1/helm、v1/store等
+	if _, ok := config.GetConfig().ApproveMap.AppstoreTransMap[endpoint]; ok {
+		endpoint = config.GetConfig().ApproveMap.AppstoreTransMap[endpoint]
+	}
+	// gRPC请求使用方法名对应的操作名称
+	if operate, ok := c.Get(constants.OperateName); ok {
+		endpoint = operate.(string)
+	}
+	return endpoint
+}
+
+// approvalDecision 记录审批判断的结果，审计日志中记录最终的结果
+func approvalDecision(c *gin.Context, decision string) {
+	c.Set(constants.ApprovalDecision, decision)
+	metrics.ApprovalDecisions.WithLabelValues(metrics.Module(c.Param("moudle")), decision).Inc()
+}
+
+func approvalResult(needApprove bool) string {
+	if needApprove {
+		return metrics.ApprovalHeld
+	}
+	return metrics.ApprovalAllowed
+}
+
+// 判断集群异常状态
+func checkClusterStatus(ctx context.Context, clusterName string) error {
+	clusterStatus, err := db.GetClusterStatus(ctx, clusterName)
 	if err != nil {
 		return err
 	}
@@ -256,8 +373,8 @@ func checkClusterStatus(clusterName string) error {
 	}
 }
 
-// 检测域名合法性
-func checkDomain(nameDomainId []db.NameDomainId) (api.Reply, error) {
+// 检测域名合法性，同一集群名称可以注册多个域名(负载均衡)
+func checkDomain(ctx context.Context, nameDomainId []db.NameDomainId) (api.Reply, error) {
 
 	if len(nameDomainId) < 1 {
 		rp := api.Reply{
@@ -266,27 +383,37 @@ func checkDomain(nameDomainId []db.NameDomainId) (api.Reply, error) {
 			Data:    "",
 		}
 		return rp, errors.New("集群不存在")
-	} else if len(nameDomainId) == 1 {
-		// check
-		clusterName := nameDomainId[0].Name
-		err := checkClusterStatus(clusterName)
-		if err != nil {
-			rp := api.Reply{
-				Code:    999,
-				Message: err.Error(),
-				Data:    "",
-			}
-			return rp, err
+	}
+	// check
+	clusterName := nameDomainId[0].Name
+	err := checkClusterStatus(ctx, clusterName)
+	if err != nil {
+		rp := api.Reply{
+			Code:    999,
+			Message: err.Error(),
+			Data:    "",
 		}
+		return rp, err
+	}
+	return api.Reply{}, nil
+}
+
+// 检测集群网关及模块健康状态，开启健康检查且配置为拒绝时，不健康的集群直接返回错误而不是等待超时
+func checkHealth(domain string, module string) (api.Reply, error) {
+	cfg := config.GetConfig().Health
+	if !cfg.Enabled || !cfg.Reject {
 		return api.Reply{}, nil
-	} else {
+	}
+	if err := health.Check(domain, module); err != nil {
 		rp := api.Reply{
-			Code:    999,
-			Message: "集群Id获取失败",
+			Code:    constants.CodeClusterUnhealthy,
+			Message: err.Error(),
 			Data:    "",
+			Module:  module,
 		}
-		return rp, errors.New("集群Id获取失败")
+		return rp, err
 	}
+	return api.Reply{}, nil
 }
 
 func InOut() gin.HandlerFunc {
@@ -311,7 +438,7 @@ func InOut() gin.HandlerFunc {
 				clusterName = Request.URL.Query().Get(constants.ClusterName)
 			}
 			// 根据集群名获取集群域名
-			nameDomainIdList, err := db.GetDomainIdByClusterName(clusterName)
+			nameDomainIdList, err := db.GetDomainIdByClusterName(c.Request.Context(), clusterName)
 			if err != nil {
 				rp := api.Reply{
 					Code:    999,
@@ -319,15 +446,33 @@ func InOut() gin.HandlerFunc {
 					Data:    "",
 				}
 				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
 			}
 			// 检测域名合法性
-			rp, err := checkDomain(nameDomainIdList)
+			rp, err := checkDomain(c.Request.Context(), nameDomainIdList)
 			if err != nil {
 				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
+			}
+			// 同一集群注册多个域名时按负载均衡策略选择
+			endpoint, err := balancer.Pick(nameDomainIdList, module, balancer.HashValue(Request.Header, clusterName))
+			if err != nil {
+				rp := api.Reply{
+					Code:    999,
+					Message: err.Error(),
+					Data:    "",
+				}
+				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
+			}
+			// 检测集群网关及模块健康状态
+			if rp, err := checkHealth(endpoint.Domain, module); err != nil {
+				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
 			}
 			// 设置集群名称、域名、目的地
 			c.Set(constants.ClusterName, clusterName)
-			c.Set(constants.ClusterDomain, nameDomainIdList[0].Domain)
+			c.Set(constants.ClusterDomain, endpoint.Domain)
 			c.Set(constants.Destination, constants.DestinationIn)
 			c.Next()
 		} else if Request.Header.Get("X-Cluster-ID") != "" { // 如果Header中有集群Id
@@ -340,9 +485,10 @@ func InOut() gin.HandlerFunc {
 					Data:    "",
 				}
 				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
 			}
 			// 根据集群Id获取集群域名
-			nameDomainList, err := db.GetNameDomainByClusterId(clusterId)
+			nameDomainList, err := db.GetNameDomainByClusterId(c.Request.Context(), clusterId)
 			if err != nil {
 				rp := api.Reply{
 					Code:    999,
@@ -350,15 +496,37 @@ func InOut() gin.HandlerFunc {
 					Data:    "",
 				}
 				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
 			}
 			//checkDomain
-			rp, err := checkDomain(nameDomainList)
+			rp, err := checkDomain(c.Request.Context(), nameDomainList)
 			if err != nil {
 				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
+			}
+			// 根据集群名获取该集群的所有域名，按负载均衡策略选择
+			clusterName := nameDomainList[0].Name
+			if list, err := db.GetDomainIdByClusterName(c.Request.Context(), clusterName); err == nil && len(list) > 0 {
+				nameDomainList = list
+			}
+			endpoint, err := balancer.Pick(nameDomainList, module, balancer.HashValue(Request.Header, clusterName))
+			if err != nil {
+				rp := api.Reply{
+					Code:    999,
+					Message: err.Error(),
+					Data:    "",
+				}
+				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
+			}
+			// 检测集群网关及模块健康状态
+			if rp, err := checkHealth(endpoint.Domain, module); err != nil {
+				c.AbortWithStatusJSON(http.StatusOK, rp)
+				return
 			}
 			// 设置集群名称、域名、目的地
-			c.Set(constants.ClusterName, nameDomainList[0].Name)
-			c.Set(constants.ClusterDomain, nameDomainList[0].Domain)
+			c.Set(constants.ClusterName, clusterName)
+			c.Set(constants.ClusterDomain, endpoint.Domain)
 			c.Set(constants.Destination, constants.DestinationIn)
 			c.Next()
 		} else {
@@ -372,12 +540,32 @@ func InOut() gin.HandlerFunc {
 	}
 }
 
+// Trace 开始请求的服务端span，请求带有W3C traceparent时继续上游的追踪，否则开始新的追踪。
+// 兼容原有的X-Trace-ID：请求中没有X-Trace-ID时按trace id生成UUID格式的X-Trace-ID，
+// 只有X-Trace-ID时以其作为trace id，日志及上游可以继续使用X-Trace-ID关联请求。
 func Trace() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		_, ok := c.Request.Header[constants.HTTPHeaderTraceID]
-		if !ok { //* X-Trace-ID is not exist, generate it.
-			id := uuid.NewString()
-			c.Request.Header.Set(constants.HTTPHeaderTraceID, id)
+		ctx, span := tracing.StartServer(c.Request.Context(), c.Request.Method+" /"+c.Param("moudle"), c.Request.Header)
+		if _, ok := c.Request.Header[constants.HTTPHeaderTraceID]; !ok { //* X-Trace-ID is not exist, generate it.
+			c.Request.Header.Set(constants.HTTPHeaderTraceID, span.SpanContext().TraceID.UUID())
+		}
+		c.Request = c.Request.WithContext(ctx)
+		defer span.End()
+		span.SetAttr("http.method", c.Request.Method)
+		span.SetAttr("http.target", c.Request.URL.Path)
+		span.SetAttr("http.client_ip", c.ClientIP())
+		span.SetAttr("ihub.module", c.Param("moudle"))
+		span.SetAttr("ihub.runmode", config.GetConfig().Runmode)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttr("http.status_code", status)
+		if cluster := requestCluster(c); cluster != "" {
+			span.SetAttr("ihub.cluster", cluster)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(status))
 		}
 	}
 }
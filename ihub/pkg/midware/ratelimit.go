@@ -0,0 +1,122 @@
+package midware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ihub/pkg/api"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/metrics"
+	"ihub/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 限流维度
+const (
+	RateLimitKeyUser    = "user"
+	RateLimitKeyGroup   = "group"
+	RateLimitKeyIP      = "ip"
+	RateLimitKeyCluster = "cluster"
+	RateLimitKeyModule  = "module"
+)
+
+// RateLimit 按用户、组、客户端IP、集群、模块进行令牌桶限流，超过限制时返回429及Retry-After
+func RateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.GetConfig()
+		module := requestModule(c)
+		backend := ratelimit.GetBackend()
+
+		// 全局规则及路由规则的令牌桶相互独立
+		scopes := []string{"global"}
+		rulesList := [][]config.RateLimitRule{cfg.RateLimit.Rules}
+		if route := requestRoute(c); route != nil && len(route.RateLimits) > 0 {
+			scopes = append(scopes, "route:"+route.Module+route.Path)
+			rulesList = append(rulesList, route.RateLimits)
+		}
+		for n, rules := range rulesList {
+			for i, rule := range rules {
+				value := rateLimitValue(c, rule.Key)
+				if value == "" || rule.Rate <= 0 {
+					continue
+				}
+				// 未配置容量时允许1秒内的突发
+				burst := rule.Burst
+				if burst < 1 {
+					burst = int(math.Ceil(rule.Rate))
+				}
+				key := fmt.Sprintf("%s:%d:%s:%s", scopes[n], i, rule.Key, value)
+				allowed, wait, err := backend.Take(key, rule.Rate, burst)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{"key": key, "error": err}).Warn("rate limit backend failed")
+					if cfg.RateLimit.FailOpen {
+						continue
+					}
+					rp := api.Reply{
+						Code:    999,
+						Message: "限流服务不可用",
+						Data:    "",
+					}
+					c.AbortWithStatusJSON(http.StatusServiceUnavailable, rp)
+					return
+				}
+				if !allowed {
+					metrics.RateLimitRejections.WithLabelValues(rule.Key, metrics.Module(module)).Inc()
+					c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+					rp := api.Reply{
+						Code:    constants.CodeRateLimited,
+						Message: fmt.Sprintf("请求过于频繁，请%s后重试", wait.Round(time.Millisecond)),
+						Data:    "",
+						Module:  module,
+					}
+					c.AbortWithStatusJSON(http.StatusTooManyRequests, rp)
+					return
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// rateLimitValue 返回请求在限流维度上的值。按用户或组限流时只使用Auth校验令牌后的身份，
+// 客户端可以伪造或省略X-User-ID、X-Group-ID，没有校验过的身份时按客户端IP限流
+func rateLimitValue(c *gin.Context, key string) string {
+	switch key {
+	case RateLimitKeyUser:
+		return verifiedOrIP(c, constants.VerifiedUserID)
+	case RateLimitKeyGroup:
+		return verifiedOrIP(c, constants.VerifiedGroupID)
+	case RateLimitKeyIP:
+		return c.ClientIP()
+	case RateLimitKeyCluster:
+		return requestCluster(c)
+	case RateLimitKeyModule:
+		return requestModule(c)
+	}
+	return ""
+}
+
+// verifiedOrIP 返回上下文中校验过的身份，没有时返回客户端IP，IP加前缀避免与用户或组ID相同
+func verifiedOrIP(c *gin.Context, key string) string {
+	if v := c.GetString(key); v != "" {
+		return v
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// requestCluster 返回请求访问的集群名称，优先使用InOut解析的结果
+func requestCluster(c *gin.Context) string {
+	if name := c.GetString(constants.ClusterName); name != "" {
+		return name
+	}
+	if name := c.Request.Header.Get(constants.HTTPHeaderClusterName); name != "" {
+		return name
+	}
+	return c.Query(constants.ClusterName)
+}
@@ -0,0 +1,127 @@
+package midware
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ihub/pkg/api"
+	"ihub/pkg/config/configtest"
+	"ihub/pkg/constants"
+	"ihub/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitEngine verified为true时代替Auth将X-User-ID作为校验后的用户
+func rateLimitEngine(verified bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if verified {
+		r.Use(func(c *gin.Context) {
+			c.Set(constants.VerifiedUserID, c.Request.Header.Get(constants.HTTPHeaderUserID))
+		})
+	}
+	r.Use(RateLimit())
+	r.Any("/:moudle/*proxyPath", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func serve(r *gin.Engine, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/appstore/v1/store/list", nil)
+	req.Header.Set(constants.HTTPHeaderUserID, user)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+// userRateLimit 每2秒一个令牌、容量为1的按用户限流
+const userRateLimit = `
+rateLimit:
+  backend: "memory"
+  rules:
+  - key: "user"
+    rate: 0.5
+    burst: 1
+`
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	configtest.Init(t, userRateLimit)
+	ratelimit.ResetMemoryBackend()
+	r := rateLimitEngine(true)
+	const user = "1001"
+	if rec := serve(r, user); rec.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", rec.Code)
+	}
+	rec := serve(r, user)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", rec.Code)
+	}
+	// 每2秒一个令牌，等待时间向上取整为2秒
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	var rp api.Reply
+	if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil || rp.Code != constants.CodeRateLimited || rp.Module != "appstore" {
+		t.Errorf("reply = %+v, %v, want code %d for appstore", rp, err, constants.CodeRateLimited)
+	}
+	// 其他用户不受影响
+	if rec := serve(r, "1002"); rec.Code != http.StatusOK {
+		t.Errorf("another user status = %d, want 200", rec.Code)
+	}
+}
+
+// TestRateLimitUnverifiedUser 没有校验过的用户时按客户端IP限流，更换X-User-ID不能绕过限流
+func TestRateLimitUnverifiedUser(t *testing.T) {
+	configtest.Init(t, userRateLimit)
+	ratelimit.ResetMemoryBackend()
+	r := rateLimitEngine(false)
+	if rec := serve(r, "1001"); rec.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", rec.Code)
+	}
+	if rec := serve(r, "1002"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("forged user status = %d, want 429", rec.Code)
+	}
+	if rec := serve(r, ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("request without user status = %d, want 429", rec.Code)
+	}
+}
+
+func TestRateLimitFailOpen(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		failOpen string
+		want     int
+	}{
+		{"true", http.StatusOK},
+		{"false", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run("failOpen="+tt.failOpen, func(t *testing.T) {
+			configtest.Init(t, `
+rateLimit:
+  backend: "redis"
+  redis:
+    addr: "`+addr+`"
+    timeout: "100ms"
+  failOpen: `+tt.failOpen+`
+  rules:
+  - key: "user"
+    rate: 1
+`)
+			if rec := serve(rateLimitEngine(true), "1001"); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package midware
+
+import (
+	"net/http"
+
+	"ihub/pkg/api"
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/limiter"
+	"ihub/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Shedding ihub过载保护，正在处理的请求过多时优先拒绝查询类请求，保证增删改请求(如部署)可以继续处理
+func Shedding() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 健康检查不参与过载保护，避免负载均衡器把繁忙的实例摘除
+		if c.FullPath() == "/health" {
+			c.Next()
+			return
+		}
+		priority := limiter.Priority(c.Request.Method)
+		leave, ok := limiter.Enter(config.GetConfig().Proxy.Shedding, priority)
+		if !ok {
+			metrics.SheddingRejections.WithLabelValues(limiter.PriorityName(priority)).Inc()
+			c.Header("Retry-After", "1")
+			rp := api.Reply{
+				Code:    constants.CodeOverloaded,
+				Message: "服务繁忙，请稍后重试",
+				Data:    "",
+			}
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, rp)
+			return
+		}
+		defer leave()
+		c.Next()
+	}
+}
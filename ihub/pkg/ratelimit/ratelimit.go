@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+)
+
+// 限流后端
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// Backend 令牌桶存储
+type Backend interface {
+	// Take 从key对应的令牌桶中取一个令牌，rate为每秒生成的令牌数，burst为桶容量。
+	// 令牌不足时返回false及需要等待的时间。
+	Take(key string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+var (
+	mu      sync.Mutex
+	current Backend
+	// currentCfg 创建current时使用的Redis配置，配置变化后重新创建后端
+	currentCfg config.RedisConfig
+	memory     = NewMemoryBackend()
+)
+
+// GetBackend 根据配置返回限流后端，内存后端在配置变化后保留已有的令牌桶
+func GetBackend() Backend {
+	cfg := config.GetConfig().RateLimit
+	mu.Lock()
+	defer mu.Unlock()
+	if cfg.Backend != BackendRedis {
+		return memory
+	}
+	if current == nil || currentCfg != cfg.Redis {
+		if closer, ok := current.(*RedisBackend); ok {
+			closer.Close()
+		}
+		current = NewRedisBackend(cfg.Redis)
+		currentCfg = cfg.Redis
+	}
+	return current
+}
+
+// ResetMemoryBackend 丢弃内存后端中的全部令牌桶，测试之间不共享令牌桶
+func ResetMemoryBackend() {
+	mu.Lock()
+	defer mu.Unlock()
+	memory = NewMemoryBackend()
+}
+
+// bucket 令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryBackend 进程内的令牌桶，多实例部署时各实例分别计数
+type MemoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewMemoryBackend .
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{buckets: map[string]*bucket{}, swept: time.Now()}
+}
+
+// Take .
+func (m *MemoryBackend) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep 每分钟清理一次已经装满(长时间没有请求)的令牌桶
+func (m *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, b := range m.buckets {
+		if now.Sub(b.last) > time.Minute {
+			delete(m.buckets, key)
+		}
+	}
+}
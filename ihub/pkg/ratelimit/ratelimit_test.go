@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBackendTake(t *testing.T) {
+	m := NewMemoryBackend()
+	for i := 0; i < 3; i++ {
+		if allowed, _, err := m.Take("k", 2, 3); err != nil || !allowed {
+			t.Fatalf("Take %d = %v, %v, want allowed within burst", i, allowed, err)
+		}
+	}
+	allowed, wait, err := m.Take("k", 2, 3)
+	if err != nil || allowed {
+		t.Fatalf("Take after burst = %v, %v, want denied", allowed, err)
+	}
+	// 每秒2个令牌，令牌用完后最多等待500ms
+	if wait <= 0 || wait > 500*time.Millisecond {
+		t.Errorf("wait = %v, want (0, 500ms]", wait)
+	}
+	// 不同的键使用各自的令牌桶
+	if allowed, _, _ := m.Take("other", 2, 3); !allowed {
+		t.Error("Take on another key denied, want allowed")
+	}
+}
+
+func TestMemoryBackendRefill(t *testing.T) {
+	m := NewMemoryBackend()
+	m.Take("k", 1, 1)
+	if allowed, _, _ := m.Take("k", 1, 1); allowed {
+		t.Fatal("Take after burst allowed, want denied")
+	}
+	// 模拟时间经过1秒，令牌桶补充一个令牌
+	m.buckets["k"].last = m.buckets["k"].last.Add(-time.Second)
+	if allowed, _, _ := m.Take("k", 1, 1); !allowed {
+		t.Error("Take after refill denied, want allowed")
+	}
+}
+
+func TestMemoryBackendSweep(t *testing.T) {
+	m := NewMemoryBackend()
+	m.Take("idle", 1, 1)
+	m.buckets["idle"].last = time.Now().Add(-2 * time.Minute)
+	m.swept = time.Now().Add(-2 * time.Minute)
+	m.Take("k", 1, 1)
+	if _, ok := m.buckets["idle"]; ok {
+		t.Error("idle bucket not swept")
+	}
+}
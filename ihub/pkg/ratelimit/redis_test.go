@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ihub/pkg/config"
+)
+
+// stubRedis 使用RESP协议的Redis替身，记录收到的命令，EVAL按handle的结果回复
+type stubRedis struct {
+	l      net.Listener
+	mu     sync.Mutex
+	cmds   [][]string
+	conns  int
+	handle func(args []string) string
+}
+
+func newStubRedis(t *testing.T, handle func(args []string) string) *stubRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubRedis{l: l, handle: handle}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *stubRedis) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *stubRedis) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *stubRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.cmds = append(s.cmds, args)
+		s.mu.Unlock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH", "SELECT":
+			reply = "+OK\r\n"
+		default:
+			reply = s.handle(args)
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *stubRedis) commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string{}, s.cmds...)
+}
+
+func (s *stubRedis) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// readCommand 读取客户端发送的RESP数组
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestRedisBackendTake(t *testing.T) {
+	replies := []string{"*2\r\n:1\r\n:0\r\n", "*2\r\n:0\r\n:250\r\n"}
+	var mu sync.Mutex
+	stub := newStubRedis(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		reply := replies[0]
+		replies = replies[1:]
+		return reply
+	})
+	r := NewRedisBackend(config.RedisConfig{Addr: stub.addr(), Password: "secret", DB: 2, KeyPrefix: "ihub:"})
+	defer r.Close()
+
+	allowed, wait, err := r.Take("user:u1", 2.5, 5)
+	if err != nil || !allowed || wait != 0 {
+		t.Fatalf("first Take = %v, %v, %v, want allowed", allowed, wait, err)
+	}
+	allowed, wait, err = r.Take("user:u1", 2.5, 5)
+	if err != nil || allowed || wait != 250*time.Millisecond {
+		t.Fatalf("second Take = %v, %v, %v, want denied with 250ms wait", allowed, wait, err)
+	}
+
+	cmds := stub.commands()
+	if len(cmds) != 4 {
+		t.Fatalf("commands = %q, want AUTH, SELECT and two EVAL", cmds)
+	}
+	if got := strings.Join(cmds[0], " "); got != "AUTH secret" {
+		t.Errorf("first command = %q, want AUTH secret", got)
+	}
+	if got := strings.Join(cmds[1], " "); got != "SELECT 2" {
+		t.Errorf("second command = %q, want SELECT 2", got)
+	}
+	eval := cmds[2]
+	if len(eval) != 7 || eval[0] != "EVAL" || eval[1] != tokenBucketScript || eval[2] != "1" {
+		t.Fatalf("EVAL command = %q", eval)
+	}
+	if eval[3] != "ihub:user:u1" || eval[4] != "2.5" || eval[5] != "5" {
+		t.Errorf("EVAL key and args = %q, want ihub:user:u1 2.5 5", eval[3:6])
+	}
+	if now, err := strconv.ParseInt(eval[6], 10, 64); err != nil || time.Since(time.UnixMilli(now)) > time.Minute {
+		t.Errorf("EVAL now = %q, want the current time in milliseconds", eval[6])
+	}
+	// 第二次请求复用连接池中的连接，不再认证
+	if n := stub.connections(); n != 1 {
+		t.Errorf("connections = %d, want 1", n)
+	}
+}
+
+func TestRedisBackendErrors(t *testing.T) {
+	t.Run("error reply keeps the connection", func(t *testing.T) {
+		stub := newStubRedis(t, func(args []string) string {
+			return "-NOSCRIPT script error\r\n"
+		})
+		r := NewRedisBackend(config.RedisConfig{Addr: stub.addr()})
+		defer r.Close()
+		for i := 0; i < 2; i++ {
+			if _, _, err := r.Take("k", 1, 1); err == nil || !strings.Contains(err.Error(), "NOSCRIPT") {
+				t.Fatalf("Take error = %v, want the redis error", err)
+			}
+		}
+		if n := stub.connections(); n != 1 {
+			t.Errorf("connections = %d, want 1", n)
+		}
+	})
+
+	t.Run("unexpected reply", func(t *testing.T) {
+		stub := newStubRedis(t, func(args []string) string {
+			return ":1\r\n"
+		})
+		r := NewRedisBackend(config.RedisConfig{Addr: stub.addr()})
+		defer r.Close()
+		if _, _, err := r.Take("k", 1, 1); err == nil {
+			t.Fatal("Take error = nil, want unexpected reply")
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		r := NewRedisBackend(config.RedisConfig{Addr: addr, Timeout: 100 * time.Millisecond})
+		if _, _, err := r.Take("k", 1, 1); err == nil {
+			t.Fatal("Take error = nil, want dial error")
+		}
+	})
+}
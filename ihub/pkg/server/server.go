@@ -3,19 +3,48 @@ package server
 import (
 	"fmt"
 	"ihub/pkg/config"
+	"ihub/pkg/constants"
 	"ihub/pkg/handler"
 	"ihub/pkg/midware"
+	"net"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type Server struct {
 	r *gin.Engine
+	// admin 管理接口，监听admin.addr，与代理端口分开
+	admin *gin.Engine
 }
 
 func (s *Server) Run() error {
+	adminAddr := config.GetConfig().Admin.Addr
+	if adminAddr == "" {
+		adminAddr = constants.DefaultAdminAddr
+	}
+	// 管理接口先监听，地址被占用时启动失败
+	ln, err := net.Listen("tcp", adminAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, s.admin); err != nil {
+			logrus.WithError(err).Error("admin server stopped")
+		}
+	}()
+
 	host := fmt.Sprintf(":%d", config.GetConfig().SERVER.Port)
-	return s.r.Run(host)
+	// 同时支持HTTP/1.1和明文HTTP/2(h2c)，gRPC客户端可以直接访问
+	srv := &http.Server{
+		Addr:    host,
+		Handler: h2c.NewHandler(s.r, &http2.Server{}),
+	}
+	return srv.ListenAndServe()
 }
 
 func NewServer() *Server {
@@ -26,12 +55,41 @@ func NewServer() *Server {
 	//*      |                   |-> Yes -> Insert db
 	//*      |-> In -> cluster gateway -> Auth -> Approve
 
+	// 管理接口单独监听，不经过InOut、Approve等中间件，认证失败及变更配置的操作都记录到审计日志
+	s.admin = gin.New()
+	s.admin.Use(gin.Recovery())
+	admin := s.admin.Group("/admin", midware.Audit(), midware.AdminAuth())
+	admin.GET("/breakers", handler.Breakers)
+	admin.GET("/health", handler.HealthTable)
+	admin.GET("/config", handler.ConfigStatus)
+	admin.GET("/config/effective", handler.EffectiveConfig)
+	admin.POST("/config/rollback", handler.ConfigRollback)
+	admin.GET("/audit/export", handler.AuditExport)
+	admin.GET("/audit/verify", handler.AuditVerify)
+	admin.GET("/log/captures", handler.LogCaptures)
+	admin.POST("/log/captures", handler.AddLogCapture)
+	admin.DELETE("/log/captures/:id", handler.RemoveLogCapture)
+	// Prometheus监控指标
+	s.r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// 请求数及耗时在过载保护之前记录，被拒绝的请求也计入
+	s.r.Use(midware.Metrics())
+	// 审计日志记录所有变更请求，包括被过载保护及中间件拒绝的请求
+	s.r.Use(midware.Audit())
+
+	// 过载保护在所有中间件之前，尽早拒绝无法处理的请求
+	s.r.Use(midware.Shedding())
+	// gRPC请求先将服务名解析为模块名称，再进入配置的中间件
+	s.r.Use(midware.GRPC())
+	// 灰度路由在配置的中间件之前选择模块版本，缓存、限流等中间件按选择的版本处理
+	s.r.Use(midware.Canary())
+	// 配置的中间件由调度器执行，配置热加载后中间件链随之更新
 	if err := midware.InitMidwares(s.r); err != nil {
 		return nil
 	}
 	s.r.Use(gin.Recovery())
 	s.r.GET("/health", handler.Health)
-	s.r.Any("/:module/*endpoint", handler.Proxy)
+	s.r.Any("/:moudle/*proxyPath", handler.Proxy)
 
 	return s
 }
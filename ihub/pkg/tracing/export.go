@@ -0,0 +1,300 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+	"ihub/pkg/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 默认的导出间隔及导出超时
+const (
+	defaultFlushInterval = 5 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// otlpExporter 将结束的span按批通过OTLP/HTTP(JSON)导出到collector。
+// endpoint等配置在每次导出时读取，热加载后立即生效。
+type otlpExporter struct {
+	once  sync.Once
+	queue chan *Span
+	flush chan chan struct{}
+	http  *http.Client
+}
+
+var exporter = &otlpExporter{http: &http.Client{}}
+
+// Start 启动后台导出，没有配置endpoint时span不进入导出队列
+func Start() {
+	exporter.once.Do(func() {
+		size := config.GetConfig().Tracing.QueueSize
+		if size <= 0 {
+			size = constants.DefaultTracingQueueSize
+		}
+		exporter.queue = make(chan *Span, size)
+		exporter.flush = make(chan chan struct{})
+		go exporter.run()
+	})
+}
+
+// Flush 立即导出队列中的span，导出完成后返回，用于退出前
+func Flush() {
+	if exporter.flush == nil {
+		return
+	}
+	done := make(chan struct{})
+	exporter.flush <- done
+	<-done
+}
+
+// enqueue 队列已满时丢弃，不阻塞请求
+func (e *otlpExporter) enqueue(s *Span) {
+	if e.queue == nil || config.GetConfig().Tracing.Endpoint == "" {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+		metrics.TracingSpans.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (e *otlpExporter) run() {
+	var batch []*Span
+	timer := time.NewTimer(flushInterval())
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < batchSize() {
+				continue
+			}
+		case <-timer.C:
+		case done := <-e.flush:
+			// 导出请求前已经入队的span
+			for n := len(e.queue); n > 0; n-- {
+				batch = append(batch, <-e.queue)
+			}
+			for len(batch) > 0 {
+				batch = e.export(batch)
+			}
+			close(done)
+			continue
+		}
+		for len(batch) > 0 {
+			batch = e.export(batch)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(flushInterval())
+	}
+}
+
+// export 导出最多batchSize个span，返回剩余的span。导出失败的span丢弃，不重试，避免collector不可用时占用内存
+func (e *otlpExporter) export(batch []*Span) []*Span {
+	n := batchSize()
+	if n > len(batch) {
+		n = len(batch)
+	}
+	spans, rest := batch[:n], batch[n:]
+	cfg := config.GetConfig().Tracing
+	if cfg.Endpoint == "" {
+		return rest
+	}
+	if err := e.post(cfg, spans); err != nil {
+		metrics.TracingSpans.WithLabelValues("failed").Add(float64(len(spans)))
+		logrus.WithFields(logrus.Fields{"endpoint": cfg.Endpoint, "spans": len(spans), "error": err}).Warn("export spans failed")
+		return rest
+	}
+	metrics.TracingSpans.WithLabelValues("exported").Add(float64(len(spans)))
+	return rest
+}
+
+func (e *otlpExporter) post(cfg config.TracingConfig, spans []*Span) error {
+	body, err := json.Marshal(encode(cfg, spans))
+	if err != nil {
+		return err
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tracesURL(cfg.Endpoint), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// tracesURL endpoint没有路径时使用OTLP/HTTP的默认路径/v1/traces
+func tracesURL(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if strings.HasSuffix(endpoint, "/v1/traces") {
+		return endpoint
+	}
+	return endpoint + "/v1/traces"
+}
+
+func batchSize() int {
+	if n := config.GetConfig().Tracing.BatchSize; n > 0 {
+		return n
+	}
+	return constants.DefaultTracingBatchSize
+}
+
+func flushInterval() time.Duration {
+	if d := config.GetConfig().Tracing.FlushInterval; d > 0 {
+		return d
+	}
+	return defaultFlushInterval
+}
+
+// 以下为OTLP/HTTP JSON格式，trace id及span id使用十六进制字符串，64位整数使用字符串
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// otlpStatus code为0(未设置)或2(错误)
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func encode(cfg config.TracingConfig, spans []*Span) otlpRequest {
+	service := cfg.ServiceName
+	if service == "" {
+		service = constants.DefaultTracingServiceName
+	}
+	resource := []otlpKeyValue{keyValue("service.name", service)}
+	if host, err := os.Hostname(); err == nil {
+		resource = append(resource, keyValue("host.name", host))
+	}
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			TraceState:        s.sc.TraceState,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent.IsValid() {
+			span.ParentSpanID = s.parent.String()
+		}
+		for _, a := range s.attrs {
+			span.Attributes = append(span.Attributes, keyValue(a.key, a.value))
+		}
+		if s.failed {
+			span.Status = otlpStatus{Code: 2, Message: s.errorText}
+		}
+		s.mu.Unlock()
+		out[i] = span
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "ihub"}, Spans: out}},
+	}}}
+}
+
+func keyValue(key string, value interface{}) otlpKeyValue {
+	var v otlpValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int:
+		s := strconv.Itoa(x)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &x
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
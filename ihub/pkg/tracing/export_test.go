@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"ihub/pkg/config/configtest"
+)
+
+// collector 进程内的OTLP/HTTP collector，记录收到的请求
+type collector struct {
+	mu       sync.Mutex
+	paths    []string
+	headers  []http.Header
+	requests []otlpRequest
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req otlpRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.paths = append(c.paths, r.URL.Path)
+	c.headers = append(c.headers, r.Header)
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+	w.Write([]byte("{}"))
+}
+
+func attrMap(attrs []otlpKeyValue) map[string]otlpValue {
+	m := map[string]otlpValue{}
+	for _, a := range attrs {
+		m[a.Key] = a.Value
+	}
+	return m
+}
+
+func TestExport(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	configtest.Init(t, `
+tracing:
+  endpoint: "`+srv.URL+`"
+  serviceName: "ihub-test"
+  sampler: "always_on"
+  headers:
+    Authorization: "Bearer collector-token"
+`)
+	Start()
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set("tracestate", "vendor=value")
+	ctx, server := StartServer(context.Background(), "GET /appstore", header)
+	server.SetAttr("http.status_code", 502)
+	server.SetAttr("ihub.cached", false)
+	server.SetAttr("ihub.ratio", 0.5)
+	_, client := StartSpan(ctx, "proxy appstore", KindClient)
+	client.SetAttr("ihub.cluster", "c1")
+	client.SetError("refused: connection refused")
+	client.End()
+	server.End()
+	Flush()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.requests) != 1 {
+		t.Fatalf("collector received %d requests, want 1", len(c.requests))
+	}
+	if c.paths[0] != "/v1/traces" {
+		t.Errorf("path = %q, want /v1/traces", c.paths[0])
+	}
+	if got := c.headers[0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := c.headers[0].Get("Authorization"); got != "Bearer collector-token" {
+		t.Errorf("Authorization = %q, want the configured header", got)
+	}
+
+	req := c.requests[0]
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("request = %+v, want one resource and one scope", req)
+	}
+	rs := req.ResourceSpans[0]
+	if v := attrMap(rs.Resource.Attributes)["service.name"]; v.StringValue == nil || *v.StringValue != "ihub-test" {
+		t.Errorf("service.name = %+v, want ihub-test", v)
+	}
+	ss := rs.ScopeSpans[0]
+	if ss.Scope.Name != "ihub" || len(ss.Spans) != 2 {
+		t.Fatalf("scope = %q with %d spans, want ihub with 2", ss.Scope.Name, len(ss.Spans))
+	}
+
+	// 子span先结束，先导出
+	proxy, srvSpan := ss.Spans[0], ss.Spans[1]
+	if srvSpan.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || srvSpan.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("server span trace %q parent %q, want the upstream traceparent", srvSpan.TraceID, srvSpan.ParentSpanID)
+	}
+	if srvSpan.SpanID != server.SpanContext().SpanID.String() || srvSpan.TraceState != "vendor=value" {
+		t.Errorf("server span id %q tracestate %q", srvSpan.SpanID, srvSpan.TraceState)
+	}
+	if srvSpan.Name != "GET /appstore" || srvSpan.Kind != KindServer || srvSpan.Status.Code != 0 {
+		t.Errorf("server span = %+v", srvSpan)
+	}
+	if srvSpan.StartTimeUnixNano == "" || srvSpan.EndTimeUnixNano < srvSpan.StartTimeUnixNano {
+		t.Errorf("server span times %q - %q", srvSpan.StartTimeUnixNano, srvSpan.EndTimeUnixNano)
+	}
+	attrs := attrMap(srvSpan.Attributes)
+	if v := attrs["http.status_code"]; v.IntValue == nil || *v.IntValue != "502" {
+		t.Errorf("http.status_code = %+v, want intValue \"502\"", v)
+	}
+	if v := attrs["ihub.cached"]; v.BoolValue == nil || *v.BoolValue {
+		t.Errorf("ihub.cached = %+v, want boolValue false", v)
+	}
+	if v := attrs["ihub.ratio"]; v.DoubleValue == nil || *v.DoubleValue != 0.5 {
+		t.Errorf("ihub.ratio = %+v, want doubleValue 0.5", v)
+	}
+
+	if proxy.TraceID != srvSpan.TraceID || proxy.ParentSpanID != srvSpan.SpanID || proxy.Kind != KindClient {
+		t.Errorf("proxy span = %+v, want a client child of the server span", proxy)
+	}
+	if proxy.Status.Code != 2 || proxy.Status.Message != "refused: connection refused" {
+		t.Errorf("proxy span status = %+v, want error", proxy.Status)
+	}
+	if v := attrMap(proxy.Attributes)["ihub.cluster"]; v.StringValue == nil || *v.StringValue != "c1" {
+		t.Errorf("ihub.cluster = %+v", v)
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := map[string]string{
+		"http://collector:4318":            "http://collector:4318/v1/traces",
+		"http://collector:4318/":           "http://collector:4318/v1/traces",
+		"http://collector:4318/v1/traces":  "http://collector:4318/v1/traces",
+		"http://collector:4318/v1/traces/": "http://collector:4318/v1/traces",
+		"http://gateway/otlp":              "http://gateway/otlp/v1/traces",
+	}
+	for endpoint, want := range tests {
+		if got := tracesURL(endpoint); got != want {
+			t.Errorf("tracesURL(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
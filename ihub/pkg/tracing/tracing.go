@@ -0,0 +1,290 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ihub/pkg/config"
+	"ihub/pkg/constants"
+)
+
+// TraceID 16字节的trace id
+type TraceID [16]byte
+
+// SpanID 8字节的span id
+type SpanID [8]byte
+
+// IsValid 全零的id无效
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// UUID 以UUID格式返回trace id，用于X-Trace-ID
+func (t TraceID) UUID() string {
+	h := t.String()
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// IsValid 全零的id无效
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext 在服务之间传递的追踪上下文
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid trace id及span id都不为零时有效
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Traceparent 返回W3C traceparent请求头的值
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent 解析W3C traceparent请求头，格式错误或id为零时返回false。
+// 未知的版本按00的格式解析前四段，版本ff无效。
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	_, err1 := decodeHex(parts[0], 1)
+	traceID, err2 := decodeHex(parts[1], 16)
+	spanID, err3 := decodeHex(parts[2], 8)
+	flags, err4 := decodeHex(parts[3], 1)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, sc.IsValid()
+}
+
+// ParseTraceID 解析X-Trace-ID，UUID或32位十六进制字符串可以作为trace id
+func ParseTraceID(value string) (TraceID, bool) {
+	var t TraceID
+	if len(value) != 32 && len(value) != 36 {
+		return t, false
+	}
+	b, err := decodeHex(strings.ToLower(strings.ReplaceAll(value, "-", "")), 16)
+	if err != nil {
+		return t, false
+	}
+	copy(t[:], b)
+	return t, t.IsValid()
+}
+
+// decodeHex 只接受指定长度的小写十六进制字符串，与W3C规范一致
+func decodeHex(s string, n int) ([]byte, error) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, fmt.Errorf("invalid hex %q", s)
+	}
+	return hex.DecodeString(s)
+}
+
+// Kind span的类型，取值与OTLP一致
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Span 一次操作的耗时及属性，未被采样的span只用于传递追踪上下文，不记录也不导出。
+// 方法可以在nil上调用，调用方不需要判断是否开启了追踪。
+type Span struct {
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu        sync.Mutex
+	end       time.Time
+	attrs     []attribute
+	errorText string
+	failed    bool
+	ended     bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// SpanContext 返回span的追踪上下文
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttr 设置属性，值为string、bool、int、int64或float64，其他类型按字符串记录
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attribute{key: key, value: value})
+}
+
+// SetError 将span标记为失败
+func (s *Span) SetError(message string) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.errorText = message
+}
+
+// End 结束span，被采样的span加入导出队列，重复调用只有第一次生效
+func (s *Span) End() {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	exporter.enqueue(s)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan 返回保存了span的context，之后开始的span以其为父span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 返回context中的span，没有时返回nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartServer 为收到的请求开始服务端span：请求带有有效的traceparent时继续上游的追踪，
+// 否则开始新的追踪，X-Trace-ID是UUID时使用其作为trace id，使两者保持一致
+func StartServer(ctx context.Context, name string, header http.Header) (context.Context, *Span) {
+	parent, ok := ParseTraceparent(header.Get(constants.HTTPHeaderTraceparent))
+	var span *Span
+	if ok {
+		parent.TraceState = header.Get(constants.HTTPHeaderTracestate)
+		span = newSpan(parent.TraceID, parent.SpanID, sample(parent.TraceID, &parent), name, KindServer)
+		span.sc.TraceState = parent.TraceState
+	} else {
+		traceID, ok := ParseTraceID(header.Get(constants.HTTPHeaderTraceID))
+		if !ok {
+			traceID = newTraceID()
+		}
+		span = newSpan(traceID, SpanID{}, sample(traceID, nil), name, KindServer)
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// StartSpan 开始context中span的子span，没有父span或父span未被采样时不创建，返回原context及nil
+func StartSpan(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil || !parent.sc.Sampled {
+		return ctx, nil
+	}
+	span := newSpan(parent.sc.TraceID, parent.sc.SpanID, true, name, kind)
+	span.sc.TraceState = parent.sc.TraceState
+	return ContextWithSpan(ctx, span), span
+}
+
+// Inject 将context中span的追踪上下文写入请求头，上游服务据此继续追踪
+func Inject(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	header.Set(constants.HTTPHeaderTraceparent, span.sc.Traceparent())
+	if span.sc.TraceState != "" {
+		header.Set(constants.HTTPHeaderTracestate, span.sc.TraceState)
+	} else {
+		header.Del(constants.HTTPHeaderTracestate)
+	}
+}
+
+func newSpan(traceID TraceID, parent SpanID, sampled bool, name string, kind Kind) *Span {
+	return &Span{
+		sc:     SpanContext{TraceID: traceID, SpanID: newSpanID(), Sampled: sampled},
+		parent: parent,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+}
+
+// sample 按配置的采样策略判断是否采样，parent为上游的追踪上下文，没有时为nil
+func sample(traceID TraceID, parent *SpanContext) bool {
+	cfg := config.GetConfig().Tracing
+	sampler := cfg.Sampler
+	if sampler == "" {
+		sampler = "parentbased_always_on"
+	}
+	if strings.HasPrefix(sampler, "parentbased_") {
+		if parent != nil {
+			return parent.Sampled
+		}
+		sampler = strings.TrimPrefix(sampler, "parentbased_")
+	}
+	switch sampler {
+	case "always_on":
+		return true
+	case "always_off":
+		return false
+	}
+	// traceidratio：与OpenTelemetry SDK相同，按trace id的后8字节判断，同一trace在各服务的结果一致
+	x := binary.BigEndian.Uint64(traceID[8:16]) >> 1
+	return x < uint64(cfg.SampleRatio*(1<<63))
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		rand.Read(t[:])
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		rand.Read(s[:])
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"ihub/pkg/config/configtest"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + spanID + "-09", true, true},
+		{"surrounding spaces", " 00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"future version", "cc-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"version 00 with extra field", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"uppercase trace id", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, false},
+		{"uppercase span id", "00-" + traceID + "-00F067AA0BA902B7-01", false, false},
+		{"uppercase version", "0A-" + traceID + "-" + spanID + "-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"short trace id", "00-" + traceID[2:] + "-" + spanID + "-01", false, false},
+		{"non hex", "00-" + traceID + "-" + spanID[:15] + "g-01", false, false},
+		{"missing flags", "00-" + traceID + "-" + spanID, false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			if ok != tt.ok {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != tt.sampled {
+				t.Errorf("ParseTraceparent(%q) = %s %s %v", tt.value, sc.TraceID, sc.SpanID, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	got, ok := ParseTraceparent(sc.Traceparent())
+	if !ok || got != sc {
+		t.Errorf("ParseTraceparent(%q) = %+v, %v, want %+v", sc.Traceparent(), got, ok, sc)
+	}
+}
+
+// traceIDWith 返回后8字节为low的trace id，traceidratio按后8字节判断
+func traceIDWith(low uint64) TraceID {
+	var t TraceID
+	t[0] = 1
+	binary.BigEndian.PutUint64(t[8:], low)
+	return t
+}
+
+func TestSample(t *testing.T) {
+	low, high := traceIDWith(0), traceIDWith(^uint64(0))
+	// 后8字节右移1位后为2^61，即0.25
+	quarter := traceIDWith(1 << 62)
+	sampled := &SpanContext{Sampled: true}
+	notSampled := &SpanContext{Sampled: false}
+	tests := []struct {
+		sampler string
+		ratio   float64
+		traceID TraceID
+		parent  *SpanContext
+		want    bool
+	}{
+		{"", 0, high, nil, true},
+		{"", 0, high, notSampled, false},
+		{"always_on", 0, high, notSampled, true},
+		{"always_off", 1, low, sampled, false},
+		{"traceidratio", 0.5, low, nil, true},
+		{"traceidratio", 0.5, high, nil, false},
+		{"traceidratio", 0.5, low, notSampled, true},
+		{"traceidratio", 0.25, quarter, nil, false},
+		{"traceidratio", 0.26, quarter, nil, true},
+		{"traceidratio", 0, low, nil, false},
+		{"traceidratio", 1, high, nil, true},
+		{"parentbased_traceidratio", 0, low, sampled, true},
+		{"parentbased_traceidratio", 1, high, notSampled, false},
+		{"parentbased_traceidratio", 0.5, low, nil, true},
+		{"parentbased_traceidratio", 0.5, high, nil, false},
+		{"parentbased_always_off", 0, low, nil, false},
+		{"parentbased_always_off", 0, low, sampled, true},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s/%v/%s/parent=%v", tt.sampler, tt.ratio, tt.traceID, tt.parent != nil && tt.parent.Sampled)
+		if tt.parent == nil {
+			name = fmt.Sprintf("%s/%v/%s/no parent", tt.sampler, tt.ratio, tt.traceID)
+		}
+		t.Run(name, func(t *testing.T) {
+			configtest.Init(t, fmt.Sprintf("tracing:\n  sampler: %q\n  sampleRatio: %v\n", tt.sampler, tt.ratio))
+			if got := sample(tt.traceID, tt.parent); got != tt.want {
+				t.Errorf("sample = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	configtest.Init(t, "tracing:\n  sampler: \"traceidratio\"\n  sampleRatio: 0.25\n")
+	const n = 20000
+	count := 0
+	for i := 0; i < n; i++ {
+		if sample(newTraceID(), nil) {
+			count++
+		}
+	}
+	if ratio := float64(count) / n; ratio < 0.23 || ratio > 0.27 {
+		t.Errorf("sampled ratio = %.3f, want about 0.25", ratio)
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"net/http"
 	"os"
 	"strings"
 
@@ -53,3 +54,23 @@ func MakeURL(domain, proxyPath string) (string, string) {
 	// 返回目标URL和真实路径
 	return targetURL, realPath
 }
+
+// IsStreamRequest 判断请求是否为长连接请求(WebSocket升级、gRPC或SSE订阅)
+func IsStreamRequest(req *http.Request) bool {
+	// WebSocket等协议升级请求，Connection头中包含upgrade
+	if req.Header.Get("Upgrade") != "" &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade") {
+		return true
+	}
+	// gRPC及gRPC-Web请求，可能为双向流
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		return true
+	}
+	// SSE订阅请求
+	return strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+}
+
+// IsStreamResponse 判断响应是否为流式响应(SSE)
+func IsStreamResponse(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}